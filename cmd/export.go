@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/civilrights3/go-derek-go/internal/eventlog"
+)
+
+const exportCommand = "export"
+
+// export converts event logs to CSV, it returns the exit code
+//
+//	derek export [-o events.csv] events-20240101-120000.jsonl events.jsonl
+func export(args []string) int {
+	fs := flag.NewFlagSet(exportCommand, flag.ContinueOnError)
+	out := fs.String("o", "", "file to write the CSV to, standard output when empty")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [-o file.csv] log.jsonl...\n", os.Args[0], exportCommand)
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var logs []io.Reader
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Printf("unable to open event log: %s\n", err)
+			return 1
+		}
+		defer f.Close()
+		logs = append(logs, f)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Printf("unable to create csv: %s\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	err = eventlog.ToCSV(w, logs...)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	return 0
+}
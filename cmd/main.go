@@ -7,12 +7,15 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/civilrights3/go-derek-go/internal/chat"
 	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/eventlog"
 	"github.com/civilrights3/go-derek-go/internal/multiworld"
 	"github.com/civilrights3/go-derek-go/internal/queue"
+	"github.com/civilrights3/go-derek-go/internal/schedule"
 	"github.com/civilrights3/go-derek-go/test/mock"
 	"gopkg.in/yaml.v3"
 )
@@ -20,6 +23,11 @@ import (
 var mockArchi = flag.Bool("mockarchi", false, "use mock archipelago messages")
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == exportCommand {
+		os.Exit(export(os.Args[2:]))
+	}
+
+	flag.Parse()
 	ctx, cancel := context.WithCancel(context.Background())
 	cfg, err := readConfig()
 	if err != nil {
@@ -32,35 +40,116 @@ func main() {
 	// catch SIGETRM or SIGINTERRUPT
 	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
 
+	// start message queue
+	q, err := queue.New(cfg.Queue)
+	if err != nil {
+		panic(fmt.Sprintf("cannot start message queue: %s\n", err))
+	}
+	//q.RegisterMessageListener("test", q.TestHandler)
+
+	var eventLog *eventlog.EventLog
+	if cfg.EventLog.File != "" {
+		eventLog, err = eventlog.New(cfg.EventLog, q)
+		if err != nil {
+			panic(fmt.Sprintf("cannot start event log: %s\n", err))
+		}
+	}
+
 	// init adapter for discord
-	discordClient, err := chat.NewDiscordClient(cfg.Chat)
+	discordClient, err := chat.NewDiscordClient(cfg.Chat, q)
 	if err != nil {
 		panic(fmt.Sprintf("error creating discord connection: %s\n", err))
 	}
 
+	sinks := []chat.Sink{discordClient}
+	if cfg.Slack.Enabled() {
+		slackClient, err := chat.NewSlackClient(cfg.Slack, q)
+		if err != nil {
+			panic(fmt.Sprintf("error creating slack connection: %s\n", err))
+		}
+		sinks = append(sinks, slackClient)
+	}
+	if cfg.Matrix.Enabled() {
+		matrixClient, err := chat.NewMatrixClient(cfg.Matrix, q)
+		if err != nil {
+			panic(fmt.Sprintf("error creating matrix connection: %s\n", err))
+		}
+		sinks = append(sinks, matrixClient)
+	}
+	if cfg.IRC.Enabled() {
+		ircClient, err := chat.NewIRCClient(cfg.IRC, q)
+		if err != nil {
+			panic(fmt.Sprintf("error creating irc connection: %s\n", err))
+		}
+		sinks = append(sinks, ircClient)
+	}
+
+	// init the adapter for archipelago, one client per room
+	var rooms []*multiworld.ArchipelagoClient
+	if !*mockArchi {
+		games, err := multiworld.NewGameCache(cfg.Multiworld.Cache)
+		if err != nil {
+			panic(fmt.Sprintf("cannot start multiworld connection: %s\n", err))
+		}
+
+		for _, room := range cfg.Multiworld.AllRooms() {
+			arch, err := multiworld.NewArchipelagoClient(cfg.Multiworld, room, games, q)
+			if err != nil {
+				panic(fmt.Sprintf("cannot start multiworld connection: %s\n", err))
+			}
+			if room.ChannelID != "" {
+				discordClient.SetRoomChannel(room.Name, room.ChannelID)
+			}
+			if room.BridgeChannelID != "" {
+				discordClient.Bridge(room.BridgeChannelID, room.Name, arch)
+			}
+			discordClient.AddRoom(arch)
+
+			rooms = append(rooms, arch)
+		}
+	}
+
 	err = discordClient.Connect()
 	if err != nil {
 		panic(fmt.Sprintf("cannot start discord connection: %s\n", err))
 	}
 
-	// start message queue
-	queue.StartMessageQueue()
-	//queue.Queue.RegisterMessageListener(queue.Queue.TestHandler)
-	queue.Queue.RegisterMessageListener(discordClient.SendMessage)
+	// the other sinks can come up later, the queue keeps their messages until they do
+	for _, sink := range sinks[1:] {
+		err = sink.Connect()
+		if err != nil {
+			fmt.Printf("cannot start %s connection, carrying on without it for now: %s\n", sink.Name(), err)
+		}
+	}
+
+	queueDone := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(queueDone)
+	}()
 
-	// init the adapter for archipelago
 	if *mockArchi {
 		fmt.Println("Sending mocked messages")
-		mock.SendTestMessages(ctx)
+		mock.SendTestMessages(ctx, q)
 		fmt.Println("Sent mocked messages")
-	} else {
-		arch, err := multiworld.NewArchipelagoClient(cfg.Multiworld)
+	}
+
+	for _, arch := range rooms {
+		fmt.Printf("Starting multiworld connection %s\n", arch.Room())
+		arch.Start(ctx)
+	}
+
+	if cfg.Summary.Schedule != "" {
+		digest, err := schedule.Parse(cfg.Summary.Schedule)
 		if err != nil {
-			panic(fmt.Sprintf("cannot start multiworld connection: %s\n", err))
+			panic(fmt.Sprintf("cannot schedule summary: %s\n", err))
 		}
-		fmt.Println("Starting multiworld connection")
-		arch.Start(ctx, cfg.Multiworld.World.Server, cfg.Multiworld.World.Port, cfg.Multiworld.World.Slot)
-		fmt.Println("Multiworld connected")
+
+		go schedule.Run(ctx, digest, func() {
+			for _, arch := range rooms {
+				arch.PublishDigest()
+			}
+		})
 	}
 
 	// build core and pass adapters
@@ -72,12 +161,54 @@ func main() {
 	}
 
 	fmt.Println("Closing...")
-	err = discordClient.Disconnect()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Queue.DrainTimeout)
+	defer shutdownCancel()
+
+	// stop taking in new events before draining, otherwise the queue may never empty
+	for _, arch := range rooms {
+		arch.StopReading()
+	}
+
+	err = q.Drain(shutdownCtx)
 	if err != nil {
-		fmt.Printf("could not disconnect from discord: %s\n", err)
+		fmt.Printf("could not deliver every queued message: %s\n", err)
+	}
+
+	for _, sink := range sinks {
+		err = sink.SignOff()
+		if err != nil {
+			fmt.Printf("could not sign off from %s: %s\n", sink.Name(), err)
+		}
+	}
+
+	for _, arch := range rooms {
+		err = arch.Close(shutdownCtx)
+		if err != nil {
+			fmt.Printf("could not close multiworld connection %s: %s\n", arch.Room(), err)
+		}
+	}
+
+	for _, sink := range sinks {
+		err = sink.Disconnect()
+		if err != nil {
+			fmt.Printf("could not disconnect from %s: %s\n", sink.Name(), err)
+		}
 	}
 
 	cancel()
+	<-queueDone
+
+	err = q.Close()
+	if err != nil {
+		fmt.Printf("could not close message queue: %s\n", err)
+	}
+
+	if eventLog != nil {
+		err = eventLog.Close()
+		if err != nil {
+			fmt.Printf("could not close event log: %s\n", err)
+		}
+	}
 }
 
 const (
@@ -106,5 +237,60 @@ func readConfig() (config.Config, error) {
 
 	cfg.Chat.Key = string(k)
 
+	err = cfg.Multiworld.CheckRooms()
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.Multiworld.World.Password, err = readPassword(cfg.Multiworld.World)
+	if err != nil {
+		return cfg, err
+	}
+
+	for i, room := range cfg.Multiworld.Rooms {
+		cfg.Multiworld.Rooms[i].Password, err = readPassword(room)
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	if cfg.Slack.TokenFile != "" {
+		t, err := os.ReadFile(cfg.Slack.TokenFile)
+		if err != nil {
+			return cfg, fmt.Errorf("unable to read slack token file: %w", err)
+		}
+		cfg.Slack.Token = strings.TrimSpace(string(t))
+	}
+
+	if cfg.Matrix.AccessTokenFile != "" {
+		t, err := os.ReadFile(cfg.Matrix.AccessTokenFile)
+		if err != nil {
+			return cfg, fmt.Errorf("unable to read matrix access token file: %w", err)
+		}
+		cfg.Matrix.AccessToken = strings.TrimSpace(string(t))
+	}
+
+	if cfg.IRC.PasswordFile != "" {
+		p, err := os.ReadFile(cfg.IRC.PasswordFile)
+		if err != nil {
+			return cfg, fmt.Errorf("unable to read irc password file: %w", err)
+		}
+		cfg.IRC.Password = strings.TrimSpace(string(p))
+	}
+
 	return cfg, nil
 }
+
+// readPassword reads the room password from its secret file, if it has one
+func readPassword(room config.World) (string, error) {
+	if room.PasswordFile == "" {
+		return room.Password, nil
+	}
+
+	p, err := os.ReadFile(room.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read room password file for %s: %w", room.Name, err)
+	}
+
+	return strings.TrimSpace(string(p)), nil
+}
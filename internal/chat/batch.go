@@ -0,0 +1,239 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord's limits for a single message
+const (
+	maxContentLength    = 2000
+	maxEmbeds           = 10
+	maxEmbedChars       = 6000
+	maxEmbedDescription = 4096
+)
+
+const ansiFence = "```ansi\n"
+
+// payload is a formatted message along with the users it should ping
+type payload struct {
+	send     *discordgo.MessageSend
+	mentions []string
+	at       position
+}
+
+// position is where a payload comes in the queue. part counts the pieces of a message that was too long to post
+// in one go.
+type position struct {
+	seq  uint64
+	part int
+}
+
+func (p position) after(o position) bool {
+	return p.seq > o.seq || p.seq == o.seq && p.part > o.part
+}
+
+// post is a Discord message ready to go, last is the latest payload packed into it
+type post struct {
+	send *discordgo.MessageSend
+	last position
+}
+
+// packer merges formatted messages into as few Discord messages as the limits allow
+type packer struct {
+	out      []post
+	content  string
+	embeds   []*discordgo.MessageEmbed
+	mentions []string
+	last     position
+}
+
+func packPayloads(payloads []payload) []post {
+	p := &packer{}
+	for _, pl := range payloads {
+		p.add(pl)
+	}
+	p.flush()
+
+	return p.out
+}
+
+func (p *packer) add(pl payload) {
+	content := mergeContent(p.content, pl.send.Content)
+	embeds := append(append([]*discordgo.MessageEmbed{}, p.embeds...), pl.send.Embeds...)
+	mentions := mergeMentions(p.mentions, pl.mentions)
+
+	fits := len(mentionPrefix(mentions))+len(content) <= maxContentLength &&
+		len(embeds) <= maxEmbeds &&
+		embedChars(embeds) <= maxEmbedChars
+	if fits {
+		p.content, p.embeds, p.mentions = content, embeds, mentions
+		if pl.at.after(p.last) {
+			p.last = pl.at
+		}
+		return
+	}
+
+	p.flush()
+	p.content = truncateContent(pl.send.Content, maxContentLength-len(mentionPrefix(pl.mentions)))
+	p.embeds = pl.send.Embeds
+	p.mentions = pl.mentions
+	p.last = pl.at
+}
+
+func (p *packer) flush() {
+	if p.content == "" && len(p.embeds) == 0 {
+		return
+	}
+
+	p.out = append(p.out, post{
+		send: &discordgo.MessageSend{
+			Content:         strings.TrimSpace(mentionPrefix(p.mentions) + p.content),
+			Embeds:          p.embeds,
+			AllowedMentions: allowedMentions(p.mentions...),
+		},
+		last: p.last,
+	})
+	p.content, p.embeds, p.mentions, p.last = "", nil, nil, position{}
+}
+
+// mergeContent joins two messages line by line. Two ansi code blocks are joined into a single block
+// so a batch in colour mode doesn't pay for a fence on every line.
+func mergeContent(a string, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	case isAnsiBlock(a) && isAnsiBlock(b):
+		return strings.TrimSuffix(a, "\n```") + "\n" + strings.TrimPrefix(b, ansiFence)
+	default:
+		return a + "\n" + b
+	}
+}
+
+func isAnsiBlock(s string) bool {
+	return strings.HasPrefix(s, ansiFence) && strings.HasSuffix(s, "\n```")
+}
+
+// splitSend breaks a message that is over Discord's limits into pieces at its lines, so a long report goes out
+// in full over several posts instead of being cut short
+func splitSend(send *discordgo.MessageSend, limit int) []*discordgo.MessageSend {
+	if len(send.Embeds) == 1 && len(send.Embeds[0].Description) > maxEmbedDescription {
+		var out []*discordgo.MessageSend
+		for _, d := range splitLines(send.Embeds[0].Description, maxEmbedDescription) {
+			e := *send.Embeds[0]
+			e.Description = d
+			out = append(out, &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{&e}})
+		}
+		return out
+	}
+
+	if len(send.Content) <= limit {
+		return []*discordgo.MessageSend{send}
+	}
+
+	var out []*discordgo.MessageSend
+	for _, c := range splitLines(send.Content, limit) {
+		out = append(out, &discordgo.MessageSend{Content: c})
+	}
+	return out
+}
+
+// splitLines breaks text into pieces of whole lines that fit the limit. A code block or span around the text is
+// closed at the end of every piece and opened again at the start of the next. A single line that is too long on
+// its own is left for truncateContent.
+func splitLines(s string, limit int) []string {
+	if len(s) <= limit {
+		return []string{s}
+	}
+
+	var start, end string
+	switch {
+	case isAnsiBlock(s):
+		start, end = ansiFence, "\n```"
+	case len(s) > 1 && strings.HasPrefix(s, "`") && strings.HasSuffix(s, "`"):
+		start, end = "`", "`"
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, start), end)
+	limit -= len(start) + len(end)
+
+	var pieces []string
+	piece := ""
+	for _, line := range strings.Split(s, "\n") {
+		switch {
+		case piece == "":
+			piece = line
+		case len(piece)+len("\n")+len(line) > limit:
+			pieces = append(pieces, start+piece+end)
+			piece = line
+		default:
+			piece += "\n" + line
+		}
+	}
+
+	return append(pieces, start+piece+end)
+}
+
+// truncateContent cuts a single oversized message down to the limit, keeping any code block closed
+func truncateContent(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+
+	suffix := "…"
+	if isAnsiBlock(s) {
+		suffix = "…\n```"
+	}
+
+	cut := limit - len(suffix)
+	// don't split a multibyte character
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+
+	return s[:cut] + suffix
+}
+
+func mergeMentions(a []string, b []string) []string {
+	out := append([]string{}, a...)
+	for _, m := range b {
+		out = appendUnique(out, m)
+	}
+
+	return out
+}
+
+func mentionPrefix(users []string) string {
+	if len(users) == 0 {
+		return ""
+	}
+
+	mentions := make([]string, 0, len(users))
+	for _, u := range users {
+		mentions = append(mentions, fmt.Sprintf("<@%s>", u))
+	}
+
+	return strings.Join(mentions, " ") + "\n"
+}
+
+func embedChars(embeds []*discordgo.MessageEmbed) int {
+	n := 0
+	for _, e := range embeds {
+		n += len(e.Title) + len(e.Description)
+		if e.Author != nil {
+			n += len(e.Author.Name)
+		}
+		if e.Footer != nil {
+			n += len(e.Footer.Text)
+		}
+		for _, f := range e.Fields {
+			n += len(f.Name) + len(f.Value)
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,181 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func textPayload(content string, mentions ...string) payload {
+	return payload{send: &discordgo.MessageSend{Content: content}, mentions: mentions}
+}
+
+func embedPayload(description string) payload {
+	return payload{send: &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{{Description: description}}}}
+}
+
+func repeatPayloads(n int, pl func(i int) payload) []payload {
+	out := make([]payload, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, pl(i))
+	}
+
+	return out
+}
+
+func TestPackPayloads(t *testing.T) {
+	line := strings.Repeat("a", 99)
+
+	tests := []struct {
+		name     string
+		payloads []payload
+		// posts is how many messages each post holds, by content lines or embeds
+		posts []int
+	}{
+		{
+			name:     "nothing",
+			payloads: nil,
+			posts:    nil,
+		},
+		{
+			name:     "lines fit in one post",
+			payloads: repeatPayloads(3, func(int) payload { return textPayload(line) }),
+			posts:    []int{3},
+		},
+		{
+			// 20 lines of 99 plus 19 newlines is 1999, the 21st doesn't fit
+			name:     "content splits at 2000 characters",
+			payloads: repeatPayloads(21, func(int) payload { return textPayload(line) }),
+			posts:    []int{20, 1},
+		},
+		{
+			// the mention in front of the post counts towards the limit too
+			name:     "mentions count towards the limit",
+			payloads: repeatPayloads(20, func(int) payload { return textPayload(line, "1234") }),
+			posts:    []int{19, 1},
+		},
+		{
+			name:     "embeds split at 10",
+			payloads: repeatPayloads(23, func(int) payload { return embedPayload("found it") }),
+			posts:    []int{10, 10, 3},
+		},
+		{
+			name:     "embeds split at 6000 characters",
+			payloads: repeatPayloads(4, func(int) payload { return embedPayload(strings.Repeat("e", 2000)) }),
+			posts:    []int{3, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := packPayloads(tt.payloads)
+			if len(out) != len(tt.posts) {
+				t.Fatalf("got %d posts, want %d", len(out), len(tt.posts))
+			}
+
+			for i, p := range out {
+				send := p.send
+				if len(send.Content) > maxContentLength {
+					t.Errorf("post %d is %d characters long", i, len(send.Content))
+				}
+				if len(send.Embeds) > maxEmbeds {
+					t.Errorf("post %d has %d embeds", i, len(send.Embeds))
+				}
+
+				got := len(send.Embeds)
+				if got == 0 {
+					got = len(strings.Split(strings.TrimSpace(strings.TrimPrefix(send.Content, "<@1234>")), "\n"))
+				}
+				if got != tt.posts[i] {
+					t.Errorf("post %d holds %d messages, want %d", i, got, tt.posts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPackPayloadsOversizedMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		suffix  string
+	}{
+		{
+			name:    "plain",
+			content: strings.Repeat("a", 2500),
+			suffix:  "…",
+		},
+		{
+			name:    "ansi block stays closed",
+			content: ansiFence + strings.Repeat("a", 2500) + "\n```",
+			suffix:  "…\n```",
+		},
+		{
+			name:    "multibyte characters aren't split",
+			content: strings.Repeat("é", 1500),
+			suffix:  "…",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := packPayloads([]payload{textPayload("short"), textPayload(tt.content), textPayload("after")})
+			if len(out) != 3 {
+				t.Fatalf("got %d posts, want the oversized message in a post of its own", len(out))
+			}
+
+			got := out[1].send.Content
+			if len(got) > maxContentLength {
+				t.Fatalf("oversized message is still %d characters long", len(got))
+			}
+			if !strings.HasSuffix(got, tt.suffix) {
+				t.Errorf("oversized message ends with %q, want %q", got[len(got)-10:], tt.suffix)
+			}
+			if !utf8.ValidString(got) {
+				t.Error("oversized message was cut inside a character")
+			}
+		})
+	}
+}
+
+func TestMergeContentJoinsAnsiBlocks(t *testing.T) {
+	a := ansiFence + "one\n```"
+	b := ansiFence + "two\n```"
+
+	got := mergeContent(a, b)
+	want := ansiFence + "one\ntwo\n```"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+		want  []string
+	}{
+		{name: "fits", s: "one\ntwo", limit: 7, want: []string{"one\ntwo"}},
+		{name: "at lines", s: "one\ntwo\nthree", limit: 8, want: []string{"one\ntwo", "three"}},
+		{name: "long line is left whole", s: "one\nthreethree\ntwo", limit: 8, want: []string{"one", "threethree", "two"}},
+		{
+			name:  "ansi block is closed and opened again",
+			s:     ansiFence + "one\ntwo\n```",
+			limit: len(ansiFence) + 8,
+			want:  []string{ansiFence + "one\n```", ansiFence + "two\n```"},
+		},
+		{name: "code span is closed and opened again", s: "`one\ntwo`", limit: 6, want: []string{"`one`", "`two`"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitLines(tt.s, tt.limit)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
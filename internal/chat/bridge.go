@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+// Speaker is a room that Discord chat can be relayed into
+type Speaker interface {
+	Say(text string) error
+}
+
+// bridge links a Discord channel with a room for two way chat
+type bridge struct {
+	room    string
+	channel string
+	speaker Speaker
+}
+
+// Bridge relays messages posted in the channel to the room, and the room's chat back to the channel.
+// It must be called before Connect.
+func (d *DiscordClient) Bridge(channelID string, room string, speaker Speaker) {
+	b := bridge{
+		room:    room,
+		channel: channelID,
+		speaker: speaker,
+	}
+	d.bridgesByChannel[channelID] = b
+	d.bridgesByRoom[room] = b
+}
+
+// HandleMessageCreate relays a message from a bridged channel into its room
+func (d *DiscordClient) HandleMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	b, ok := d.bridgesByChannel[m.ChannelID]
+	if !ok || m.Author == nil {
+		return
+	}
+
+	// never relay the bot's own posts, they are the room's chat coming the other way
+	if m.Author.Bot || (s.State != nil && s.State.User != nil && m.Author.ID == s.State.User.ID) {
+		return
+	}
+
+	text := strings.Join(strings.Fields(m.Content), " ")
+	if text == "" {
+		return
+	}
+
+	err := b.speaker.Say(fmt.Sprintf("%s: %s", displayName(m), text))
+	if err != nil {
+		fmt.Printf("unable to relay message to %s: %s\n", b.room, err)
+	}
+}
+
+func displayName(m *discordgo.MessageCreate) string {
+	if m.Member != nil && m.Member.Nick != "" {
+		return m.Member.Nick
+	}
+	if m.Author.GlobalName != "" {
+		return m.Author.GlobalName
+	}
+
+	return m.Author.Username
+}
+
+// bridgeChannel is where a room's chat gets relayed to, if the room is bridged at all
+func (d *DiscordClient) bridgeChannel(msg queue.BroadcastMessage) (string, bool) {
+	if msg.Type != queue.EventChat && msg.Type != queue.EventServerChat {
+		return "", false
+	}
+
+	b, ok := d.bridgesByRoom[msg.Room]
+	return b.channel, ok
+}
@@ -0,0 +1,495 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+	"github.com/civilrights3/go-derek-go/internal/room"
+)
+
+// Room is a multiworld room the slash commands can answer questions about and act on
+type Room interface {
+	Status() room.Status
+	RequestHint(ctx context.Context, slot string, item string) ([]queue.BroadcastMessage, error)
+	Hints(slot int) []queue.BroadcastMessage
+	Summary() queue.BroadcastMessage
+}
+
+// commandOptions are the options a slash command was called with, by name
+type commandOptions map[string]*discordgo.ApplicationCommandInteractionDataOption
+
+func (o commandOptions) string(name string) string {
+	opt, ok := o[name]
+	if !ok {
+		return ""
+	}
+
+	return strings.TrimSpace(opt.StringValue())
+}
+
+// command is a slash command and what answers it
+type command struct {
+	definition *discordgo.ApplicationCommand
+	handle     func(d *DiscordClient, i *discordgo.InteractionCreate, opts commandOptions) *discordgo.InteractionResponseData
+	// deferred commands take longer than Discord waits for an answer, so the answer is edited in afterwards
+	deferred bool
+	// ephemeral answers are only shown to whoever used the command
+	ephemeral bool
+}
+
+var (
+	roomOption = &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "room",
+		Description: "Only look in this room",
+	}
+	slotOption = &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "slot",
+		Description: "Slot name, alias or number",
+		Required:    true,
+	}
+)
+
+// commands is every slash command the bot registers, keyed by name
+var commands = map[string]command{
+	"status": {
+		definition: &discordgo.ApplicationCommand{
+			Name:        "status",
+			Description: "Show which rooms Derek is connected to",
+			Options:     []*discordgo.ApplicationCommandOption{roomOption},
+		},
+		handle: (*DiscordClient).handleStatusCommand,
+	},
+	"players": {
+		definition: &discordgo.ApplicationCommand{
+			Name:        "players",
+			Description: "List the players in a room",
+			Options:     []*discordgo.ApplicationCommandOption{roomOption},
+		},
+		handle: (*DiscordClient).handlePlayersCommand,
+	},
+	"progress": {
+		definition: &discordgo.ApplicationCommand{
+			Name:        "progress",
+			Description: "Show how far along a slot is",
+			Options:     []*discordgo.ApplicationCommandOption{slotOption, roomOption},
+		},
+		handle: (*DiscordClient).handleProgressCommand,
+	},
+	"game": {
+		definition: &discordgo.ApplicationCommand{
+			Name:        "game",
+			Description: "Show which game a slot is playing",
+			Options:     []*discordgo.ApplicationCommandOption{slotOption, roomOption},
+		},
+		handle: (*DiscordClient).handleGameCommand,
+	},
+	"hint": {
+		definition: &discordgo.ApplicationCommand{
+			Name:        "hint",
+			Description: "Spend your hint points on an item, your Discord account has to be linked to the slot",
+			Options: []*discordgo.ApplicationCommandOption{
+				slotOption,
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "item",
+					Description: "Item name, as the game calls it",
+					Required:    true,
+				},
+				roomOption,
+			},
+		},
+		handle:    (*DiscordClient).handleHintCommand,
+		deferred:  true,
+		ephemeral: true,
+	},
+	"hints": {
+		definition: &discordgo.ApplicationCommand{
+			Name:        "hints",
+			Description: "List the hints for or in a slot that haven't been found yet",
+			Options:     []*discordgo.ApplicationCommandOption{slotOption, roomOption},
+		},
+		handle: (*DiscordClient).handleHintsCommand,
+	},
+	"summary": {
+		definition: &discordgo.ApplicationCommand{
+			Name:        "summary",
+			Description: "Show everyone's statistics so far",
+			Options:     []*discordgo.ApplicationCommandOption{roomOption},
+		},
+		handle: (*DiscordClient).handleSummaryCommand,
+	},
+}
+
+// AddRoom makes a room available to the slash commands. It must be called before Connect.
+func (d *DiscordClient) AddRoom(room Room) {
+	d.rooms = append(d.rooms, room)
+}
+
+// registerCommands replaces the bot's slash commands in the guild with the current set
+func (d *DiscordClient) registerCommands(s *discordgo.Session, appID string) error {
+	definitions := make([]*discordgo.ApplicationCommand, 0, len(commands))
+	for _, c := range commands {
+		definitions = append(definitions, c.definition)
+	}
+
+	_, err := s.ApplicationCommandBulkOverwrite(appID, d.guildID, definitions)
+	if err != nil {
+		return fmt.Errorf("unable to register slash commands: %w", err)
+	}
+
+	return nil
+}
+
+// HandleInteractionCreate answers a slash command
+func (d *DiscordClient) HandleInteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	c, ok := commands[data.Name]
+	if !ok {
+		return
+	}
+
+	opts := make(commandOptions)
+	for _, o := range data.Options {
+		opts[o.Name] = o
+	}
+
+	var flags discordgo.MessageFlags
+	if c.ephemeral {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+
+	if !c.deferred {
+		resp := c.handle(d, i, opts)
+		pieces := answerPieces(resp.Content)
+		resp.Content = pieces[0]
+		// answers quote player names straight from the room, they must never ping anyone
+		resp.AllowedMentions = allowedMentions()
+		resp.Flags = flags
+
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: resp,
+		})
+		if err != nil {
+			fmt.Printf("unable to respond to /%s: %s\n", data.Name, err)
+			return
+		}
+		followUp(s, i, data.Name, pieces[1:], flags)
+		return
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: flags,
+		},
+	})
+	if err != nil {
+		fmt.Printf("unable to respond to /%s: %s\n", data.Name, err)
+		return
+	}
+
+	go func() {
+		resp := c.handle(d, i, opts)
+		pieces := answerPieces(resp.Content)
+		_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content:         &pieces[0],
+			AllowedMentions: allowedMentions(),
+		})
+		if err != nil {
+			fmt.Printf("unable to answer /%s: %s\n", data.Name, err)
+			return
+		}
+		followUp(s, i, data.Name, pieces[1:], flags)
+	}()
+}
+
+// answerPieces splits an answer at its lines into as many messages as it takes, so a long /summary is shown in full
+func answerPieces(content string) []string {
+	pieces := splitLines(content, maxContentLength)
+	for i, p := range pieces {
+		pieces[i] = truncateContent(p, maxContentLength)
+	}
+
+	return pieces
+}
+
+// followUp posts the rest of an answer that didn't fit in one message
+func followUp(s *discordgo.Session, i *discordgo.InteractionCreate, name string, pieces []string, flags discordgo.MessageFlags) {
+	for _, p := range pieces {
+		_, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content:         p,
+			AllowedMentions: allowedMentions(),
+			Flags:           flags,
+		})
+		if err != nil {
+			fmt.Printf("unable to finish answering /%s: %s\n", name, err)
+			return
+		}
+	}
+}
+
+// interactionUser is the Discord user who used a command, whether in a guild or a DM
+func interactionUser(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+
+	return ""
+}
+
+func reply(format string, args ...any) *discordgo.InteractionResponseData {
+	return &discordgo.InteractionResponseData{
+		Content: fmt.Sprintf(format, args...),
+	}
+}
+
+// statuses is the status of every room the command asked about, or of every room when it didn't name one
+func (d *DiscordClient) statuses(opts commandOptions) []room.Status {
+	name := opts.string("room")
+
+	var out []room.Status
+	for _, r := range d.rooms {
+		status := r.Status()
+		if name == "" || strings.EqualFold(status.Room, name) {
+			out = append(out, status)
+		}
+	}
+
+	return out
+}
+
+// roomNamed finds a room by the name its messages are tagged with
+func (d *DiscordClient) roomNamed(name string) (Room, bool) {
+	for _, r := range d.rooms {
+		if r.Status().Room == name {
+			return r, true
+		}
+	}
+
+	return nil, false
+}
+
+// findSlot looks for a slot by name, alias or number across the rooms the command asked about
+func (d *DiscordClient) findSlot(opts commandOptions) (room.Status, room.Player, bool) {
+	slot := opts.string("slot")
+	number, numErr := strconv.Atoi(slot)
+
+	for _, status := range d.statuses(opts) {
+		if numErr == nil {
+			for _, p := range status.Players {
+				if p.Slot == number {
+					return status, p, true
+				}
+			}
+		}
+
+		p, ok := status.FindPlayer(slot)
+		if ok {
+			return status, p, true
+		}
+	}
+
+	return room.Status{}, room.Player{}, false
+}
+
+// roomHeading names the room in an answer, but only when there is more than one to tell apart
+func (d *DiscordClient) roomHeading(status room.Status) string {
+	if len(d.rooms) < 2 || status.Room == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("«%s» ", status.Room)
+}
+
+func (d *DiscordClient) handleStatusCommand(_ *discordgo.InteractionCreate, opts commandOptions) *discordgo.InteractionResponseData {
+	statuses := d.statuses(opts)
+	if len(statuses) == 0 {
+		return reply("Derek isn't watching any rooms")
+	}
+
+	sb := strings.Builder{}
+	for _, status := range statuses {
+		room := status.Room
+		if room == "" {
+			room = "The room"
+		}
+
+		if !status.Connected {
+			sb.WriteString(fmt.Sprintf("%s: not connected\n", room))
+			continue
+		}
+
+		players, goals := 0, 0
+		for _, p := range status.Players {
+			if p.IsPlayer {
+				players++
+			}
+			if p.IsPlayer && p.Goal {
+				goals++
+			}
+		}
+		sb.WriteString(fmt.Sprintf("%s: connected to %s as %s (%d hint points), %d/%d players done, tracking since <t:%d:R>\n", room, status.Address, status.Slot, status.HintPoints, goals, players, status.Since.Unix()))
+	}
+
+	return reply("%s", sb.String())
+}
+
+func (d *DiscordClient) handlePlayersCommand(_ *discordgo.InteractionCreate, opts commandOptions) *discordgo.InteractionResponseData {
+	statuses := d.statuses(opts)
+	if len(statuses) == 0 {
+		return reply("Derek isn't watching any rooms")
+	}
+
+	sb := strings.Builder{}
+	for _, status := range statuses {
+		if len(statuses) > 1 {
+			sb.WriteString(fmt.Sprintf("**%s**\n", status.Room))
+		}
+		if len(status.Players) == 0 {
+			sb.WriteString("No players known yet\n")
+			continue
+		}
+
+		for _, p := range status.Players {
+			if !p.IsPlayer {
+				continue
+			}
+
+			sb.WriteString(fmt.Sprintf("%d. %s", p.Slot, p.Name))
+			if p.Alias != "" && p.Alias != p.Name {
+				sb.WriteString(fmt.Sprintf(" (%s)", p.Alias))
+			}
+			sb.WriteString(fmt.Sprintf(" - %s, %s\n", p.Game, playerState(p)))
+		}
+	}
+
+	return reply("%s", sb.String())
+}
+
+func (d *DiscordClient) handleProgressCommand(_ *discordgo.InteractionCreate, opts commandOptions) *discordgo.InteractionResponseData {
+	status, p, ok := d.findSlot(opts)
+	if !ok {
+		return reply("No slot called %s", opts.string("slot"))
+	}
+
+	checks := fmt.Sprintf("%d", p.Checked)
+	if p.Total > 0 {
+		checks = fmt.Sprintf("%d/%d (%d%%)", p.Checked, p.Total, p.Checked*100/p.Total)
+	}
+
+	return reply("%s%s is %s with %s checks and %d items received, counting since <t:%d:R>", d.roomHeading(status), p.Name, playerState(p), checks, p.ItemsReceived, status.Since.Unix())
+}
+
+// playerState is the client status of a player in words
+func playerState(p room.Player) string {
+	if p.Goal {
+		return "done"
+	}
+
+	return p.Status.String()
+}
+
+func (d *DiscordClient) handleGameCommand(_ *discordgo.InteractionCreate, opts commandOptions) *discordgo.InteractionResponseData {
+	status, p, ok := d.findSlot(opts)
+	if !ok {
+		return reply("No slot called %s", opts.string("slot"))
+	}
+
+	return reply("%s%s is playing %s", d.roomHeading(status), p.Name, p.Game)
+}
+
+func (d *DiscordClient) handleHintCommand(i *discordgo.InteractionCreate, opts commandOptions) *discordgo.InteractionResponseData {
+	status, p, ok := d.findSlot(opts)
+	if !ok {
+		return reply("No slot called %s", opts.string("slot"))
+	}
+
+	// hints cost the slot's points, so only whoever plays it gets to spend them
+	userID, linked := d.mentions.users[p.Name]
+	if !linked || userID != interactionUser(i) {
+		return reply("Your Discord account isn't linked to %s, ask for it to be added to the mentions users", p.Name)
+	}
+
+	r, ok := d.roomNamed(status.Room)
+	if !ok {
+		return reply("No room called %s", status.Room)
+	}
+
+	answers, err := r.RequestHint(context.Background(), p.Name, opts.string("item"))
+	if errors.Is(err, room.ErrNoHintAnswer) {
+		return reply("The server didn't answer, try again in a bit")
+	}
+	if err != nil {
+		fmt.Printf("unable to request hint for %s: %s\n", p.Name, err)
+		return reply("Couldn't ask the server for a hint: %s", err)
+	}
+
+	lines := make([]string, 0, len(answers))
+	for _, msg := range answers {
+		lines = append(lines, renderPlain(describe(msg, msg.Sender == msg.Receiver)))
+	}
+
+	return reply("%s%s", d.roomHeading(status), strings.Join(lines, "\n"))
+}
+
+func (d *DiscordClient) handleHintsCommand(_ *discordgo.InteractionCreate, opts commandOptions) *discordgo.InteractionResponseData {
+	status, p, ok := d.findSlot(opts)
+	if !ok {
+		return reply("No slot called %s", opts.string("slot"))
+	}
+
+	r, ok := d.roomNamed(status.Room)
+	if !ok {
+		return reply("No room called %s", status.Room)
+	}
+
+	hints := r.Hints(p.Slot)
+	if len(hints) == 0 {
+		return reply("%s%s has no outstanding hints", d.roomHeading(status), p.Name)
+	}
+
+	lines := make([]string, 0, len(hints)+1)
+	lines = append(lines, fmt.Sprintf("%s%d outstanding hints for %s", d.roomHeading(status), len(hints), p.Name))
+	for _, msg := range hints {
+		lines = append(lines, renderPlain(describe(msg, msg.Sender == msg.Receiver)))
+	}
+
+	return reply("%s", strings.Join(lines, "\n"))
+}
+
+func (d *DiscordClient) handleSummaryCommand(_ *discordgo.InteractionCreate, opts commandOptions) *discordgo.InteractionResponseData {
+	name := opts.string("room")
+
+	var reports []string
+	for _, r := range d.rooms {
+		msg := r.Summary()
+		if name != "" && !strings.EqualFold(msg.Room, name) {
+			continue
+		}
+		if len(msg.Summary) == 0 {
+			continue
+		}
+		reports = append(reports, renderPlain(layout(msg, false)))
+	}
+
+	if len(reports) == 0 {
+		return reply("No players known yet")
+	}
+
+	return reply("%s", strings.Join(reports, "\n\n"))
+}
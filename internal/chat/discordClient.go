@@ -2,6 +2,7 @@ package chat
 
 import (
 	"fmt"
+
 	"github.com/bwmarrin/discordgo"
 	"github.com/civilrights3/go-derek-go/internal/config"
 	"github.com/civilrights3/go-derek-go/internal/queue"
@@ -12,6 +13,18 @@ type DiscordClient struct {
 	channelID        string
 	guildID          string
 	messageFormatter textHandler
+	mentions         mentionRules
+	// roomChannels sends a room's announcements somewhere other than the default channel
+	roomChannels map[string]string
+	routes       []route
+	// bridgesByChannel and bridgesByRoom are the same bridges looked up from either end
+	bridgesByChannel map[string]bridge
+	bridgesByRoom    map[string]bridge
+	// rooms are what the slash commands answer from
+	rooms []Room
+	// posted is how far each channel got, so a batch the queue sends again after a later post failed doesn't
+	// repeat the posts that went through
+	posted map[string]position
 }
 
 var (
@@ -19,14 +32,32 @@ var (
 		config.DisplayPlain:      formatPlainMessage,
 		config.DisplayMonospaced: formatMonospacedMessage,
 		config.DisplayColor:      formatColorMessage,
+		config.DisplayEmbed:      formatEmbedMessage,
 	}
 )
 
-func NewDiscordClient(cfg config.Chat) (*DiscordClient, error) {
+// NewDiscordClient builds the Discord sink and subscribes it to the queue
+func NewDiscordClient(cfg config.Chat, subscriber queue.Subscriber) (*DiscordClient, error) {
+	mentions, err := newMentionRules(cfg.Mentions)
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := newRoutes(cfg.Routes)
+	if err != nil {
+		return nil, err
+	}
+
 	c := &DiscordClient{
 		channelID:        cfg.ChannelID,
 		guildID:          cfg.GuildID,
 		messageFormatter: formattingFuncs[cfg.DisplayMode],
+		mentions:         mentions,
+		roomChannels:     make(map[string]string),
+		routes:           routes,
+		bridgesByChannel: make(map[string]bridge),
+		bridgesByRoom:    make(map[string]bridge),
+		posted:           make(map[string]position),
 	}
 
 	discord, err := discordgo.New(fmt.Sprintf("Bot %s", cfg.Key))
@@ -36,13 +67,25 @@ func NewDiscordClient(cfg config.Chat) (*DiscordClient, error) {
 	discord.ShouldRetryOnRateLimit = true
 	discord.ShouldReconnectOnError = true
 
-	discord.Identify.Intents = discordgo.IntentGuildMessages
+	discord.Identify.Intents = discordgo.IntentGuildMessages | discordgo.IntentMessageContent
 	discord.AddHandler(c.HandleOnReady)
+	discord.AddHandler(c.HandleMessageCreate)
+	discord.AddHandler(c.HandleInteractionCreate)
 
 	c.discord = discord
+	subscriber.RegisterMessageListener(c.Name(), c.SendMessages)
 	return c, nil
 }
 
+func (d *DiscordClient) Name() string {
+	return "discord"
+}
+
+// SetRoomChannel sends everything from a room to its own channel. It must be called before Connect.
+func (d *DiscordClient) SetRoomChannel(room string, channelID string) {
+	d.roomChannels[room] = channelID
+}
+
 func (d *DiscordClient) Connect() error {
 	return d.discord.Open()
 }
@@ -52,19 +95,152 @@ func (d *DiscordClient) Disconnect() error {
 }
 
 func (d *DiscordClient) HandleOnReady(s *discordgo.Session, m *discordgo.Ready) {
-	_, err := d.discord.ChannelMessageSend(d.channelID, "Engaging Maximum Derek!")
-	if err != nil {
-		fmt.Println(fmt.Errorf("unable to send message: %w", err))
+	if m.Application != nil {
+		err := d.registerCommands(s, m.Application.ID)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	for _, channelID := range d.allChannels() {
+		_, err := d.discord.ChannelMessageSend(channelID, "Engaging Maximum Derek!")
+		if err != nil {
+			fmt.Println(fmt.Errorf("unable to send message: %w", err))
+		}
 	}
 }
 
-func (d *DiscordClient) SendMessage(msg queue.BroadcastMessage) error {
-	selfFind := msg.Sender == msg.Receiver
+// SignOff lets every channel know the bot is going away
+func (d *DiscordClient) SignOff() error {
+	for _, channelID := range d.allChannels() {
+		_, err := d.discord.ChannelMessageSend(channelID, "Derek signing off")
+		if err != nil {
+			return fmt.Errorf("unable to send message: %w", err)
+		}
+	}
 
-	// TODO how do i do @?
-	_, err := d.discord.ChannelMessageSend(d.channelID, d.messageFormatter(msg, selfFind))
-	if err != nil {
-		return fmt.Errorf("unable to send message: %w", err)
+	return nil
+}
+
+// allChannels is every channel the bot posts announcements to
+func (d *DiscordClient) allChannels() []string {
+	channels := []string{d.channelID}
+	for _, c := range d.roomChannels {
+		channels = appendUnique(channels, c)
+	}
+
+	return channels
+}
+
+// channelsFor works out where a message should be posted. Every matching route gets a copy, when no
+// route matches it goes to the room's channel or the default one.
+func (d *DiscordClient) channelsFor(msg queue.BroadcastMessage) []string {
+	// chat from a bridged room only goes back to its bridge, on top of whatever routes ask for it
+	var channels []string
+	bridged, ok := d.bridgeChannel(msg)
+	if ok {
+		channels = append(channels, bridged)
+	}
+
+	for _, r := range d.routes {
+		if r.matches(msg) {
+			for _, c := range r.channels {
+				channels = appendUnique(channels, c)
+			}
+		}
+	}
+	if len(channels) > 0 {
+		return channels
+	}
+
+	return []string{d.primaryChannel(msg.Room)}
+}
+
+// primaryChannel is the room's own channel, or the default one when it doesn't have one
+func (d *DiscordClient) primaryChannel(room string) string {
+	c, ok := d.roomChannels[room]
+	if ok {
+		return c
+	}
+
+	return d.channelID
+}
+
+// pingChannel is the one channel a message pings from, so a player isn't pinged once for every copy. That is
+// the room's primary channel when the message goes there, otherwise the first channel it goes to.
+func (d *DiscordClient) pingChannel(room string, channels []string) string {
+	primary := d.primaryChannel(room)
+	for _, c := range channels {
+		if c == primary {
+			return c
+		}
+	}
+
+	return channels[0]
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func appendUnique(list []string, s string) []string {
+	if contains(list, s) {
+		return list
+	}
+
+	return append(list, s)
+}
+
+// SendMessages posts a batch of messages, merged into as few Discord messages as the limits allow
+func (d *DiscordClient) SendMessages(msgs []queue.BroadcastMessage) error {
+	// group by channel, keeping the order the channels first show up in
+	var channels []string
+	payloads := make(map[string][]payload)
+	for _, msg := range msgs {
+		selfFind := msg.Sender == msg.Receiver
+
+		userID, ok := d.mentions.mentionFor(msg, selfFind)
+		limit := maxContentLength
+		if ok {
+			limit -= len(mentionPrefix([]string{userID}))
+		}
+		pieces := splitSend(d.messageFormatter(msg, selfFind), limit)
+
+		targets := d.channelsFor(msg)
+		ping := d.pingChannel(msg.Room, targets)
+		for _, c := range targets {
+			for i, send := range pieces {
+				pl := payload{send: send, at: position{seq: msg.Seq, part: i}}
+				if msg.Seq != 0 && !pl.at.after(d.posted[c]) {
+					continue
+				}
+				channels = appendUnique(channels, c)
+
+				if ok && c == ping && i == 0 {
+					pl.mentions = []string{userID}
+				}
+				payloads[c] = append(payloads[c], pl)
+			}
+		}
+	}
+
+	for _, c := range channels {
+		for _, p := range packPayloads(payloads[c]) {
+			_, err := d.discord.ChannelMessageSendComplex(c, p.send)
+			if err != nil {
+				return fmt.Errorf("unable to send message: %w", err)
+			}
+
+			if p.last.after(d.posted[c]) {
+				d.posted[c] = p.last
+			}
+		}
 	}
 
 	return nil
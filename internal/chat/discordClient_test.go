@@ -0,0 +1,227 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+func TestPingChannel(t *testing.T) {
+	d := &DiscordClient{
+		channelID:    "default",
+		roomChannels: map[string]string{"async": "async-room"},
+	}
+
+	tests := []struct {
+		name     string
+		room     string
+		channels []string
+		want     string
+	}{
+		{name: "room channel among routes", room: "async", channels: []string{"traps", "async-room"}, want: "async-room"},
+		{name: "default channel among routes", room: "other", channels: []string{"traps", "default"}, want: "default"},
+		{name: "only routed elsewhere", room: "async", channels: []string{"traps", "progression"}, want: "traps"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := d.pingChannel(tt.room, tt.channels)
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// discordStandIn is Discord's REST API for posting to channels, it keeps what was posted where
+type discordStandIn struct {
+	*httptest.Server
+	lock     sync.Mutex
+	channels []string
+	posts    []discordgo.MessageSend
+	// fail makes the posts with these numbers, counting from 1, fail
+	fail     map[int]bool
+	requests int
+}
+
+func newDiscordStandIn(t *testing.T) *discordStandIn {
+	s := &discordStandIn{fail: map[int]bool{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.requests++
+		if s.fail[s.requests] {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"Missing Access","code":50001}`))
+			return
+		}
+
+		send := discordgo.MessageSend{}
+		err := json.NewDecoder(r.Body).Decode(&send)
+		if err != nil {
+			t.Errorf("posted body isn't a message: %s", err)
+		}
+		channel := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/channels/"), "/messages")
+		s.channels = append(s.channels, channel)
+		s.posts = append(s.posts, send)
+		_, _ = w.Write([]byte(`{"id":"1","channel_id":"` + channel + `"}`))
+	}))
+	t.Cleanup(s.Close)
+
+	endpoint := discordgo.EndpointChannels
+	discordgo.EndpointChannels = s.URL + "/channels/"
+	t.Cleanup(func() { discordgo.EndpointChannels = endpoint })
+
+	return s
+}
+
+func TestDiscordRetryOnlyPostsTheRest(t *testing.T) {
+	// the batch goes out as posts of 10, 10 and 5 to the default channel and 5 to the async room's channel
+	batch := itemSends(1, 30)
+	for i := 25; i < 30; i++ {
+		batch[i].Room = "async"
+	}
+	wantChannels := []string{"main", "main", "main", "async-room"}
+	wantEmbeds := []int{maxEmbeds, maxEmbeds, 5, 5}
+
+	for failed := 1; failed <= len(wantEmbeds); failed++ {
+		t.Run(fmt.Sprintf("post %d fails", failed), func(t *testing.T) {
+			standIn := newDiscordStandIn(t)
+			standIn.fail[failed] = true
+			d, err := NewDiscordClient(config.Chat{Key: "x", ChannelID: "main", DisplayMode: config.DisplayEmbed}, noSubscriber{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.SetRoomChannel("async", "async-room")
+
+			err = d.SendMessages(batch)
+			if err == nil {
+				t.Fatal("the failed post wasn't reported")
+			}
+			// the queue sends the whole batch again
+			err = d.SendMessages(batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(standIn.posts) != len(wantEmbeds) {
+				t.Fatalf("got %d posts, want %d", len(standIn.posts), len(wantEmbeds))
+			}
+			for i, p := range standIn.posts {
+				if standIn.channels[i] != wantChannels[i] || len(p.Embeds) != wantEmbeds[i] {
+					t.Errorf("post %d has %d embeds in %s, want %d in %s", i+1, len(p.Embeds), standIn.channels[i], wantEmbeds[i], wantChannels[i])
+				}
+			}
+		})
+	}
+}
+
+func report(players int) queue.BroadcastMessage {
+	msg := queue.BroadcastMessage{Seq: 1, Type: queue.EventDigest, Count: 1, Total: players}
+	for i := 0; i < players; i++ {
+		msg.Summary = append(msg.Summary, queue.SlotSummary{
+			Name:       fmt.Sprintf("Player%02d", i),
+			Goal:       i == 0,
+			Checks:     120,
+			Total:      300,
+			Sent:       map[string]int{"progression": 12, "helpful": 30, "normal": 60, "trap": 3},
+			Received:   map[string]int{"progression": 11, "helpful": 25, "normal": 70, "trap": 4},
+			Hints:      6,
+			AverageGap: 95 * time.Second,
+			LongestGap: 41 * time.Minute,
+		})
+	}
+
+	return msg
+}
+
+func TestDiscordPostsLongReportsInFull(t *testing.T) {
+	tests := []struct {
+		mode    config.DisplayMode
+		players int
+	}{
+		{mode: config.DisplayPlain, players: 12},
+		{mode: config.DisplayMonospaced, players: 12},
+		{mode: config.DisplayColor, players: 12},
+		{mode: config.DisplayColor, players: 40},
+		{mode: config.DisplayEmbed, players: 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d players", tt.mode, tt.players), func(t *testing.T) {
+			standIn := newDiscordStandIn(t)
+			d, err := NewDiscordClient(config.Chat{Key: "x", ChannelID: "main", DisplayMode: tt.mode}, noSubscriber{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = d.SendMessages([]queue.BroadcastMessage{report(tt.players)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			all := ""
+			for i, p := range standIn.posts {
+				if len(p.Content) > maxContentLength {
+					t.Errorf("post %d is %d characters long", i, len(p.Content))
+				}
+				if strings.Contains(p.Content, "…") {
+					t.Errorf("post %d was cut short", i)
+				}
+				all += p.Content
+				for _, e := range p.Embeds {
+					if len(e.Description) > maxEmbedDescription {
+						t.Errorf("post %d has a description %d characters long", i, len(e.Description))
+					}
+					all += e.Description
+				}
+			}
+			for _, s := range report(tt.players).Summary {
+				if strings.Count(all, s.Name) != 1 {
+					t.Errorf("%s is in the posts %d times", s.Name, strings.Count(all, s.Name))
+				}
+			}
+		})
+	}
+}
+
+func TestDiscordRetryCarriesOnPartWayThroughAReport(t *testing.T) {
+	standIn := newDiscordStandIn(t)
+	standIn.fail[2] = true
+	d, err := NewDiscordClient(config.Chat{Key: "x", ChannelID: "main", DisplayMode: config.DisplayPlain}, noSubscriber{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := []queue.BroadcastMessage{report(40)}
+	err = d.SendMessages(batch)
+	if err == nil {
+		t.Fatal("the failed post wasn't reported")
+	}
+	err = d.SendMessages(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(standIn.posts) < 3 {
+		t.Fatalf("the report went out in %d posts, want it split", len(standIn.posts))
+	}
+	all := ""
+	for _, p := range standIn.posts {
+		all += p.Content
+	}
+	for _, s := range batch[0].Summary {
+		if strings.Count(all, s.Name) != 1 {
+			t.Errorf("%s is in the posts %d times", s.Name, strings.Count(all, s.Name))
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+const (
+	EmbedColorNeutral     = 0x2b2d31
+	EmbedColorNormal      = 0xdcddde
+	EmbedColorHelpful     = 0x3b82f6
+	EmbedColorProgression = 0xaf52de
+	EmbedColorTrap        = 0xe5484d
+)
+
+var (
+	importanceToEmbedColor = map[queue.ItemImportanceFlag]int{
+		queue.ItemNormal:      EmbedColorNormal,
+		queue.ItemHelpful:     EmbedColorHelpful,
+		queue.ItemProgression: EmbedColorProgression,
+		queue.ItemTrap:        EmbedColorTrap,
+	}
+
+	eventTitles = map[queue.EventType]string{
+		queue.EventItemSend:    "Item sent",
+		queue.EventItemCheat:   "Item cheated",
+		queue.EventHint:        "Hint",
+		queue.EventJoin:        "Player joined",
+		queue.EventPart:        "Player left",
+		queue.EventChat:        "Chat",
+		queue.EventServerChat:  "Server",
+		queue.EventGoal:        "Goal complete",
+		queue.EventProgress:    "Progress",
+		queue.EventDigest:      "Summary",
+		queue.EventFinalReport: "Final report",
+		queue.EventRelease:     "Release",
+		queue.EventCollect:     "Collect",
+		queue.EventCountdown:   "Countdown",
+		queue.EventTagsChanged: "Tags changed",
+	}
+)
+
+// embedColor picks the colour of the most important flag, so progression that is also helpful stays purple
+func embedColor(f queue.ItemImportanceFlag) int {
+	switch {
+	case f&queue.ItemTrap != 0:
+		return EmbedColorTrap
+	case f&queue.ItemProgression != 0:
+		return EmbedColorProgression
+	default:
+		c, ok := importanceToEmbedColor[f]
+		if !ok {
+			return EmbedColorNormal
+		}
+		return c
+	}
+}
+
+func isItemEvent(t queue.EventType) bool {
+	return t == queue.EventItemSend || t == queue.EventItemCheat || t == queue.EventHint || t == ""
+}
+
+func formatEmbedMessage(msg queue.BroadcastMessage, isSelfFind bool) *discordgo.MessageSend {
+	embed := &discordgo.MessageEmbed{
+		Title:       eventTitles[msg.Type],
+		Description: renderPlain(describe(msg, isSelfFind)),
+		Color:       EmbedColorNeutral,
+	}
+
+	if msg.Room != "" {
+		embed.Author = &discordgo.MessageEmbedAuthor{Name: msg.Room}
+	}
+
+	if isItemEvent(msg.Type) {
+		embed.Color = embedColor(msg.Importance)
+		embed.Fields = []*discordgo.MessageEmbedField{
+			{Name: "Sender", Value: orDash(msg.Sender), Inline: true},
+			{Name: "Receiver", Value: orDash(msg.Receiver), Inline: true},
+			{Name: "Item", Value: orDash(msg.Item), Inline: false},
+			{Name: "Location", Value: orDash(msg.Location), Inline: false},
+		}
+		if msg.Entrance != "" {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Entrance", Value: msg.Entrance, Inline: false})
+		}
+
+		// the item belongs to the receiver's game
+		if msg.ReceiverGame != "" {
+			embed.Footer = &discordgo.MessageEmbedFooter{Text: msg.ReceiverGame}
+		}
+	}
+
+	return &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{embed},
+	}
+}
+
+// orDash keeps embed fields from being rejected for an empty value
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+var (
+	importanceNames = map[string]queue.ItemImportanceFlag{
+		"normal":      queue.ItemNormal,
+		"progression": queue.ItemProgression,
+		"helpful":     queue.ItemHelpful,
+		"trap":        queue.ItemTrap,
+	}
+)
+
+// importanceMatcher matches item flags by name. Normal is the absence of every flag so it can't live in the mask.
+type importanceMatcher struct {
+	mask   queue.ItemImportanceFlag
+	normal bool
+}
+
+func newImportanceMatcher(names []string) (importanceMatcher, error) {
+	m := importanceMatcher{}
+	for _, n := range names {
+		f, ok := importanceNames[strings.ToLower(n)]
+		if !ok {
+			return m, fmt.Errorf("unknown item importance %s", n)
+		}
+
+		if f == queue.ItemNormal {
+			m.normal = true
+		}
+		m.mask |= f
+	}
+
+	return m, nil
+}
+
+func (m importanceMatcher) matches(f queue.ItemImportanceFlag) bool {
+	if f == queue.ItemNormal {
+		return m.normal
+	}
+
+	return f&m.mask != 0
+}
@@ -0,0 +1,611 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+const (
+	// ircTimeout covers connecting and getting into the channel, and any single write
+	ircTimeout = 30 * time.Second
+	// ircMaxText is how much text goes in one message. The server puts the bot's full address in front when it
+	// passes the message on and the whole line has to stay under 512 bytes.
+	ircMaxText = 400
+	// ircPingAfter is how quiet the server can be before the bot checks the connection is still there
+	ircPingAfter    = 3 * time.Minute
+	ircMinReconnect = 5 * time.Second
+	ircMaxReconnect = 5 * time.Minute
+
+	ircColor = "\x03"
+	ircReset = "\x0f"
+)
+
+var (
+	// ansiToIRC turns the colours of the color display mode into mIRC colour codes. They are always two digits so
+	// text that starts with a number isn't read as part of the code.
+	ansiToIRC = map[string]string{
+		ColorNeutral: ircReset,
+		ColorGold:    ircColor + "07",
+		// grey, white disappears on clients with a light background
+		ColorWhite:   ircColor + "14",
+		ColorMagenta: ircColor + "06",
+		ColorBlue:    ircColor + "12",
+		ColorRed:     ircColor + "04",
+		ColorTeal:    ircColor + "10",
+		ColorGreen:   ircColor + "03",
+	}
+
+	// ircControls stops text from the room changing the formatting or ending the line early
+	ircControls = strings.NewReplacer("\x02", "", "\x03", "", "\x0f", "", "\x16", "", "\x1d", "", "\x1f", "", "\r", "")
+)
+
+// IRCClient posts announcements to an IRC channel, reconnecting by itself whenever the connection drops
+type IRCClient struct {
+	cfg config.IRC
+
+	lock sync.Mutex
+	conn net.Conn
+	// nick is the nick the server gave us, it has underscores added when the configured one was taken
+	nick string
+	// ready is whether the bot is in the channel, messages are refused until it is so the queue retries them
+	ready  bool
+	closed bool
+
+	// writeLock keeps lines whole and the flood clock in order
+	writeLock sync.Mutex
+	// floodClock is the RFC 1459 penalty clock. Every line moves it on by the flood delay and the bot waits
+	// whenever it gets more than a burst of lines ahead of now.
+	floodClock time.Time
+
+	// posted is the last message that went out whole, and partSeq and partLines how many lines of the one after it
+	// did. A batch the queue sends again after a line failed carries on from there instead of repeating them.
+	posted    uint64
+	partSeq   uint64
+	partLines int
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type ircMessage struct {
+	prefix  string
+	command string
+	params  []string
+}
+
+// ircSegment is a run of text in one colour
+type ircSegment struct {
+	color string
+	text  string
+}
+
+// NewIRCClient builds the IRC sink and subscribes it to the queue
+func NewIRCClient(cfg config.IRC, subscriber queue.Subscriber) (*IRCClient, error) {
+	if cfg.Channel == "" || cfg.Nick == "" {
+		return nil, fmt.Errorf("irc needs a nick and a channel to post to")
+	}
+	if cfg.SASL && cfg.Password == "" {
+		return nil, fmt.Errorf("irc sasl needs a password")
+	}
+	if cfg.Account == "" {
+		cfg.Account = cfg.Nick
+	}
+
+	c := &IRCClient{
+		cfg:  cfg,
+		nick: cfg.Nick,
+	}
+
+	subscriber.RegisterMessageListener(c.Name(), c.SendMessages)
+	return c, nil
+}
+
+func (c *IRCClient) Name() string {
+	return "irc"
+}
+
+// Connect tries to get into the channel before returning. Whether it does or not, connecting again whenever the
+// connection is missing is handled in the background from then on.
+func (c *IRCClient) Connect() error {
+	conn, r, err := c.session()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go c.run(ctx, conn, r)
+
+	if err != nil {
+		return fmt.Errorf("%w, retrying in the background", err)
+	}
+
+	return c.say("Engaging Maximum Derek!")
+}
+
+func (c *IRCClient) SignOff() error {
+	return c.say("Derek signing off")
+}
+
+func (c *IRCClient) Disconnect() error {
+	c.lock.Lock()
+	c.closed = true
+	c.ready = false
+	conn := c.conn
+	c.lock.Unlock()
+
+	if c.cancel == nil {
+		return nil
+	}
+	c.cancel()
+
+	if conn != nil {
+		_ = c.send(conn, "QUIT :bye")
+		conn.Close()
+	}
+	<-c.done
+
+	return nil
+}
+
+// SendMessages posts every message as one or more lines
+func (c *IRCClient) SendMessages(msgs []queue.BroadcastMessage) error {
+	for _, msg := range unsent(msgs, c.posted) {
+		lines := splitIRC(ircSegments(layout(msg, msg.Sender == msg.Receiver)), ircMaxText)
+		skip := 0
+		if msg.Seq != 0 && msg.Seq == c.partSeq && c.partLines <= len(lines) {
+			skip = c.partLines
+		}
+
+		for i, line := range lines[skip:] {
+			err := c.say(line)
+			if err != nil {
+				if msg.Seq != 0 {
+					c.partSeq, c.partLines = msg.Seq, skip+i
+				}
+				return err
+			}
+		}
+
+		if msg.Seq > c.posted {
+			c.posted = msg.Seq
+		}
+	}
+
+	return nil
+}
+
+func ircSegments(parts []textPart) []ircSegment {
+	segments := make([]ircSegment, 0, len(parts))
+	for _, p := range parts {
+		color, ok := ansiToIRC[partColor(p)]
+		if !ok {
+			color = ircReset
+		}
+		segments = append(segments, ircSegment{color: color, text: ircControls.Replace(decorate(p))})
+	}
+
+	return segments
+}
+
+// splitIRC breaks the text into lines that fit in a message, at spaces where it can and at every newline. The
+// colour in use is carried on to the next line, clients reset it at the start of each message.
+func splitIRC(segments []ircSegment, limit int) []string {
+	var lines []string
+	sb := strings.Builder{}
+	color := ircReset
+	// written is whether the line has any text yet, a line of nothing but colour codes isn't sent
+	written := false
+
+	flush := func() {
+		if written {
+			lines = append(lines, sb.String())
+		}
+		sb.Reset()
+		written = false
+		if color != ircReset {
+			sb.WriteString(color)
+		}
+	}
+
+	for _, s := range segments {
+		if s.color != color {
+			sb.WriteString(s.color)
+			color = s.color
+		}
+
+		for i, row := range strings.Split(s.text, "\n") {
+			if i > 0 {
+				flush()
+			}
+
+			for _, word := range strings.SplitAfter(row, " ") {
+				if written && sb.Len()+len(word) > limit {
+					flush()
+				}
+
+				// a word longer than a whole line is cut where it has to be, but never inside a character
+				for sb.Len()+len(word) > limit {
+					cut := limit - sb.Len()
+					for cut > 0 && !utf8.RuneStart(word[cut]) {
+						cut--
+					}
+					if cut == 0 {
+						_, cut = utf8.DecodeRuneInString(word)
+					}
+
+					sb.WriteString(word[:cut])
+					written = true
+					word = word[cut:]
+					flush()
+				}
+
+				if word != "" {
+					sb.WriteString(word)
+					written = true
+				}
+			}
+		}
+	}
+	flush()
+
+	return lines
+}
+
+// say sends a line to the channel
+func (c *IRCClient) say(text string) error {
+	c.lock.Lock()
+	conn, ready := c.conn, c.ready
+	c.lock.Unlock()
+
+	if !ready {
+		return fmt.Errorf("not in irc channel %s, waiting to reconnect", c.cfg.Channel)
+	}
+
+	err := c.send(conn, fmt.Sprintf("PRIVMSG %s :%s", c.cfg.Channel, text))
+	if err != nil {
+		// closing it wakes the reader up so it reconnects
+		conn.Close()
+		return fmt.Errorf("unable to send irc message: %w", err)
+	}
+
+	return nil
+}
+
+// send writes a line, waiting first if the bot is over the flood limit
+func (c *IRCClient) send(conn net.Conn, line string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	now := time.Now()
+	if c.floodClock.Before(now) {
+		c.floodClock = now
+	}
+	c.floodClock = c.floodClock.Add(c.cfg.FloodDelay)
+
+	wait := c.floodClock.Sub(now) - time.Duration(c.cfg.FloodBurst)*c.cfg.FloodDelay
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	err := conn.SetWriteDeadline(time.Now().Add(ircTimeout))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(conn, strings.NewReplacer("\r", "", "\n", " ").Replace(line)+"\r\n")
+	return err
+}
+
+// session connects, logs in and joins the channel, it returns once the bot is in the channel
+func (c *IRCClient) session() (net.Conn, *bufio.Reader, error) {
+	dialer := &net.Dialer{Timeout: ircTimeout}
+
+	var conn net.Conn
+	var err error
+	if c.cfg.TLS {
+		host, _, _ := net.SplitHostPort(c.cfg.Server)
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.cfg.Server, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", c.cfg.Server)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to irc server %s: %w", c.cfg.Server, err)
+	}
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		conn.Close()
+		return nil, nil, fmt.Errorf("irc client is closed")
+	}
+	c.conn = conn
+	c.nick = c.cfg.Nick
+	c.lock.Unlock()
+
+	r := bufio.NewReader(conn)
+	err = c.register(conn, r)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	c.setReady(true)
+	fmt.Printf("Joined irc channel %s on %s\n", c.cfg.Channel, c.cfg.Server)
+	return conn, r, nil
+}
+
+// register goes through logging in, with SASL if it is turned on, and joining the channel
+func (c *IRCClient) register(conn net.Conn, r *bufio.Reader) error {
+	err := conn.SetReadDeadline(time.Now().Add(ircTimeout))
+	if err != nil {
+		return err
+	}
+
+	if c.cfg.SASL {
+		err = c.send(conn, "CAP REQ :sasl")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.send(conn, "NICK "+c.cfg.Nick)
+	if err != nil {
+		return err
+	}
+	err = c.send(conn, fmt.Sprintf("USER %s 0 * :Derek", c.cfg.Nick))
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("lost irc connection while joining: %w", err)
+		}
+
+		m := parseIRC(line)
+		switch m.command {
+		case "PING":
+			err = c.send(conn, "PONG :"+m.param(0))
+		case "CAP":
+			switch m.param(1) {
+			case "ACK":
+				err = c.send(conn, "AUTHENTICATE PLAIN")
+			case "NAK":
+				return fmt.Errorf("irc server %s doesn't support sasl", c.cfg.Server)
+			}
+		case "AUTHENTICATE":
+			if m.param(0) == "+" {
+				plain := fmt.Sprintf("%s\x00%s\x00%s", c.cfg.Account, c.cfg.Account, c.cfg.Password)
+				err = c.send(conn, "AUTHENTICATE "+base64.StdEncoding.EncodeToString([]byte(plain)))
+			}
+		case "903":
+			err = c.send(conn, "CAP END")
+		case "902", "904", "905", "906":
+			return fmt.Errorf("irc sasl login failed: %s", m.param(len(m.params)-1))
+		case "433":
+			// the nick is taken, which is usually our own connection that hasn't timed out yet
+			c.lock.Lock()
+			c.nick += "_"
+			nick := c.nick
+			c.lock.Unlock()
+			err = c.send(conn, "NICK "+nick)
+		case "001":
+			c.lock.Lock()
+			c.nick = m.param(0)
+			c.lock.Unlock()
+
+			if !c.cfg.SASL && c.cfg.Password != "" {
+				err = c.send(conn, fmt.Sprintf("PRIVMSG NickServ :IDENTIFY %s %s", c.cfg.Account, c.cfg.Password))
+				if err != nil {
+					return err
+				}
+			}
+			err = c.send(conn, c.joinLine())
+		case "JOIN":
+			if c.isOwnJoin(m) {
+				return conn.SetReadDeadline(time.Time{})
+			}
+		case "403", "405", "471", "473", "474", "475":
+			return fmt.Errorf("unable to join irc channel %s: %s", c.cfg.Channel, m.param(len(m.params)-1))
+		case "ERROR":
+			return fmt.Errorf("irc server refused the connection: %s", m.param(0))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// run reads from the server until the client is closed, reconnecting whenever the connection drops. It starts
+// by reconnecting when there isn't a connection yet.
+func (c *IRCClient) run(ctx context.Context, conn net.Conn, r *bufio.Reader) {
+	defer close(c.done)
+
+	if conn == nil {
+		conn, r = c.reconnect(ctx)
+		if conn == nil {
+			return
+		}
+	}
+
+	for {
+		err := c.read(conn, r)
+		c.setReady(false)
+		conn.Close()
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("lost irc connection: %s\n", err)
+
+		conn, r = c.reconnect(ctx)
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect keeps trying with a growing delay until the bot is back in the channel or the client is closed
+func (c *IRCClient) reconnect(ctx context.Context) (net.Conn, *bufio.Reader) {
+	delay := ircMinReconnect
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-time.After(delay):
+		}
+
+		conn, r, err := c.session()
+		if err == nil {
+			return conn, r
+		}
+
+		delay *= 2
+		if delay > ircMaxReconnect {
+			delay = ircMaxReconnect
+		}
+		fmt.Printf("unable to reconnect to irc, retry in %s: %s\n", delay, err)
+	}
+}
+
+// read handles the server's lines until the connection drops. The server is pinged once it has gone quiet, so a
+// dead connection is noticed even when there is nothing to send.
+func (c *IRCClient) read(conn net.Conn, r *bufio.Reader) error {
+	pinged := false
+	// pending is the start of a line that was cut off by the read deadline
+	pending := ""
+	for {
+		err := conn.SetReadDeadline(time.Now().Add(ircPingAfter))
+		if err != nil {
+			return err
+		}
+
+		line, err := r.ReadString('\n')
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && !pinged {
+			pending += line
+			pinged = true
+			err = c.send(conn, "PING :derek")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		line, pending = pending+line, ""
+		pinged = false
+
+		m := parseIRC(line)
+		switch m.command {
+		case "PING":
+			err = c.send(conn, "PONG :"+m.param(0))
+		case "KICK":
+			if strings.EqualFold(m.param(0), c.cfg.Channel) && m.param(1) == c.currentNick() {
+				fmt.Printf("kicked from irc channel %s, rejoining: %s\n", c.cfg.Channel, m.param(2))
+				c.setReady(false)
+				err = c.send(conn, c.joinLine())
+			}
+		case "JOIN":
+			if c.isOwnJoin(m) {
+				c.setReady(true)
+			}
+		case "NICK":
+			if ircNick(m.prefix) == c.currentNick() {
+				c.lock.Lock()
+				c.nick = m.param(0)
+				c.lock.Unlock()
+			}
+		case "ERROR":
+			return fmt.Errorf("irc server closed the connection: %s", m.param(0))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *IRCClient) joinLine() string {
+	if c.cfg.ChannelKey != "" {
+		return fmt.Sprintf("JOIN %s %s", c.cfg.Channel, c.cfg.ChannelKey)
+	}
+	return "JOIN " + c.cfg.Channel
+}
+
+func (c *IRCClient) isOwnJoin(m ircMessage) bool {
+	return ircNick(m.prefix) == c.currentNick() && strings.EqualFold(m.param(0), c.cfg.Channel)
+}
+
+func (c *IRCClient) currentNick() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.nick
+}
+
+func (c *IRCClient) setReady(ready bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ready = ready && !c.closed
+}
+
+// parseIRC splits a line from the server into its prefix, command and parameters, message tags are dropped
+func parseIRC(line string) ircMessage {
+	line = strings.TrimRight(line, "\r\n")
+	m := ircMessage{}
+
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		m.prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			m.params = append(m.params, line[1:])
+			break
+		}
+
+		var p string
+		p, line, _ = strings.Cut(line, " ")
+		if p == "" {
+			continue
+		}
+		if m.command == "" {
+			m.command = strings.ToUpper(p)
+		} else {
+			m.params = append(m.params, p)
+		}
+	}
+
+	return m
+}
+
+func (m ircMessage) param(i int) string {
+	if i < 0 || i >= len(m.params) {
+		return ""
+	}
+	return m.params[i]
+}
+
+// ircNick is the nick part of a nick!user@host prefix
+func ircNick(prefix string) string {
+	nick, _, _ := strings.Cut(prefix, "!")
+	return nick
+}
@@ -0,0 +1,469 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+)
+
+func TestSplitIRC(t *testing.T) {
+	magenta := ansiToIRC[ColorMagenta]
+
+	tests := []struct {
+		name     string
+		segments []ircSegment
+		limit    int
+		want     []string
+	}{
+		{
+			name:     "fits",
+			segments: []ircSegment{{color: ircReset, text: "Link found it"}},
+			limit:    400,
+			want:     []string{"Link found it"},
+		},
+		{
+			name:     "nothing",
+			segments: []ircSegment{{color: magenta, text: ""}},
+			limit:    400,
+			want:     nil,
+		},
+		{
+			name:     "splits at spaces",
+			segments: []ircSegment{{color: ircReset, text: "aaa bbb ccc"}},
+			limit:    8,
+			want:     []string{"aaa bbb ", "ccc"},
+		},
+		{
+			name:     "every newline starts a line",
+			segments: []ircSegment{{color: ircReset, text: "one\ntwo\n\nthree"}},
+			limit:    400,
+			want:     []string{"one", "two", "three"},
+		},
+		{
+			name:     "colour is carried to the next line",
+			segments: []ircSegment{{color: magenta, text: "aaa bbb"}},
+			limit:    8,
+			want:     []string{magenta + "aaa ", magenta + "bbb"},
+		},
+		{
+			name:     "colour is carried over a newline",
+			segments: []ircSegment{{color: ircReset, text: "x "}, {color: magenta, text: "a\nb"}, {color: ircReset, text: " y"}},
+			limit:    400,
+			want:     []string{"x " + magenta + "a", magenta + "b" + ircReset + " y"},
+		},
+		{
+			name:     "word longer than a line",
+			segments: []ircSegment{{color: ircReset, text: "ab abcdefghij"}},
+			limit:    4,
+			want:     []string{"ab ", "abcd", "efgh", "ij"},
+		},
+		{
+			name:     "long word isn't cut inside a character",
+			segments: []ircSegment{{color: ircReset, text: "ééé"}},
+			limit:    3,
+			want:     []string{"é", "é", "é"},
+		},
+		{
+			name:     "a character that doesn't fit after the colour still goes out",
+			segments: []ircSegment{{color: magenta, text: "éé"}},
+			limit:    4,
+			want:     []string{magenta + "é", magenta + "é"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitIRC(tt.segments, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIRC(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want ircMessage
+	}{
+		{
+			name: "ping",
+			line: "PING :irc.example.org\r\n",
+			want: ircMessage{command: "PING", params: []string{"irc.example.org"}},
+		},
+		{
+			name: "tags and prefix",
+			line: "@time=2026-03-14T12:00:00.000Z;account=link :link!l@example.org PRIVMSG #games :hi there\r\n",
+			want: ircMessage{prefix: "link!l@example.org", command: "PRIVMSG", params: []string{"#games", "hi there"}},
+		},
+		{
+			name: "cap ack",
+			line: ":irc.example.org CAP * ACK :sasl\r\n",
+			want: ircMessage{prefix: "irc.example.org", command: "CAP", params: []string{"*", "ACK", "sasl"}},
+		},
+		{
+			name: "authenticate",
+			line: "AUTHENTICATE +\r\n",
+			want: ircMessage{command: "AUTHENTICATE", params: []string{"+"}},
+		},
+		{
+			name: "sasl numeric",
+			line: ":irc.example.org 904 derek :SASL authentication failed\r\n",
+			want: ircMessage{prefix: "irc.example.org", command: "904", params: []string{"derek", "SASL authentication failed"}},
+		},
+		{
+			name: "lower case command and extra spaces",
+			line: ":derek!d@example.org  join   #games\n",
+			want: ircMessage{prefix: "derek!d@example.org", command: "JOIN", params: []string{"#games"}},
+		},
+		{
+			name: "empty trailing",
+			line: "PRIVMSG #games :\r\n",
+			want: ircMessage{command: "PRIVMSG", params: []string{"#games", ""}},
+		},
+		{
+			name: "trailing keeps its colons and spaces",
+			line: ":s 474 derek #games :Cannot join channel (+b) :(\r\n",
+			want: ircMessage{prefix: "s", command: "474", params: []string{"derek", "#games", "Cannot join channel (+b) :("}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseIRC(tt.line)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	if nick := ircNick("derek!d@example.org"); nick != "derek" {
+		t.Errorf("ircNick got %q", nick)
+	}
+}
+
+// ircRecorder is a connection that keeps what is written to it and when, and fails the writes it is told to
+type ircRecorder struct {
+	net.Conn
+	lock  sync.Mutex
+	lines []string
+	times []time.Time
+	// fail makes the writes with these numbers, counting from 1, fail
+	fail   map[int]bool
+	writes int
+}
+
+func (r *ircRecorder) Write(b []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.writes++
+	if r.fail[r.writes] {
+		return 0, errors.New("connection reset")
+	}
+
+	r.lines = append(r.lines, string(b))
+	r.times = append(r.times, time.Now())
+	return len(b), nil
+}
+
+func (r *ircRecorder) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (r *ircRecorder) Close() error {
+	return nil
+}
+
+func TestIRCSendKeepsToTheFloodLimit(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	conn := &ircRecorder{}
+	c := &IRCClient{cfg: config.IRC{FloodDelay: delay, FloodBurst: 2}}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		err := c.send(conn, fmt.Sprintf("PRIVMSG #games :%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the burst goes out straight away, every line after it waits a flood delay more than the one before
+	if got := conn.times[1].Sub(start); got > delay/2 {
+		t.Errorf("the burst took %s", got)
+	}
+	for i := 2; i < 4; i++ {
+		want := time.Duration(i-1) * delay
+		if got := conn.times[i].Sub(start); got < want {
+			t.Errorf("line %d went %s after the start, want at least %s", i, got, want)
+		}
+	}
+
+	// once the clock has caught up there is a full burst again
+	time.Sleep(4 * delay)
+	start = time.Now()
+	for i := 0; i < 2; i++ {
+		err := c.send(conn, "PING :derek")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := time.Since(start); got > delay/2 {
+		t.Errorf("the burst after a rest took %s", got)
+	}
+
+	if conn.lines[0] != "PRIVMSG #games :0\r\n" {
+		t.Errorf("wrote %q", conn.lines[0])
+	}
+}
+
+func TestIRCRetryOnlySendsTheRest(t *testing.T) {
+	conn := &ircRecorder{fail: map[int]bool{3: true}}
+	c := &IRCClient{cfg: config.IRC{Channel: "#games"}, conn: conn, ready: true}
+
+	batch := itemSends(1, 3)
+	// the second message goes out as three lines
+	batch[1].Location = "Chest\nbehind\nthe waterfall"
+	all := 5
+
+	err := c.SendMessages(batch)
+	if err == nil {
+		t.Fatal("the failed line wasn't reported")
+	}
+	// the queue sends the whole batch again
+	err = c.SendMessages(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conn.lines) != all {
+		t.Fatalf("sent %d lines, want %d: %q", len(conn.lines), all, conn.lines)
+	}
+	want := &ircRecorder{}
+	c = &IRCClient{cfg: config.IRC{Channel: "#games"}, conn: want, ready: true}
+	err = c.SendMessages(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conn.lines, want.lines) {
+		t.Errorf("sent %q, want %q", conn.lines, want.lines)
+	}
+}
+
+// ircStandIn is an IRC server that answers each line it gets with the replies set for it
+type ircStandIn struct {
+	net.Listener
+	lock  sync.Mutex
+	lines []string
+}
+
+func newIRCStandIn(t *testing.T, replies map[string][]string) *ircStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ircStandIn{Listener: ln}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\r\n")
+
+			s.lock.Lock()
+			s.lines = append(s.lines, line)
+			s.lock.Unlock()
+
+			for _, reply := range replies[line] {
+				_, err = fmt.Fprintf(conn, "%s\r\n", reply)
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *ircStandIn) received() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+func TestIRCRegister(t *testing.T) {
+	plain := "AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("derek\x00derek\x00hunter2"))
+	joined := []string{":derek!d@example.org JOIN #games"}
+
+	tests := []struct {
+		name    string
+		cfg     config.IRC
+		replies map[string][]string
+		// sent are lines the bot has to send, in this order
+		sent    []string
+		wantErr string
+		nick    string
+	}{
+		{
+			name: "sasl",
+			cfg:  config.IRC{Nick: "derek", Channel: "#games", Password: "hunter2", SASL: true},
+			replies: map[string][]string{
+				"CAP REQ :sasl":      {":s CAP * ACK :sasl"},
+				"AUTHENTICATE PLAIN": {"AUTHENTICATE +"},
+				plain:                {":s 900 derek derek!d@example.org derek :You are now logged in", ":s 903 derek :SASL authentication successful"},
+				"CAP END":            {":s 001 derek :Welcome"},
+				"JOIN #games":        joined,
+			},
+			sent: []string{"CAP REQ :sasl", "NICK derek", "AUTHENTICATE PLAIN", plain, "CAP END", "JOIN #games"},
+			nick: "derek",
+		},
+		{
+			name: "sasl refused",
+			cfg:  config.IRC{Nick: "derek", Channel: "#games", Password: "hunter2", SASL: true},
+			replies: map[string][]string{
+				"CAP REQ :sasl":      {":s CAP * ACK :sasl"},
+				"AUTHENTICATE PLAIN": {"AUTHENTICATE +"},
+				plain:                {":s 904 derek :SASL authentication failed"},
+			},
+			wantErr: "sasl login failed: SASL authentication failed",
+		},
+		{
+			name: "no sasl on the server",
+			cfg:  config.IRC{Nick: "derek", Channel: "#games", Password: "hunter2", SASL: true},
+			replies: map[string][]string{
+				"CAP REQ :sasl": {":s CAP * NAK :sasl"},
+			},
+			wantErr: "doesn't support sasl",
+		},
+		{
+			name: "nickserv and a taken nick",
+			cfg:  config.IRC{Nick: "derek", Channel: "#games", ChannelKey: "key", Password: "hunter2"},
+			replies: map[string][]string{
+				"NICK derek":      {":s 433 * derek :Nickname is already in use"},
+				"NICK derek_":     {"PING :s", ":s 001 derek_ :Welcome"},
+				"JOIN #games key": {":derek_!d@example.org JOIN #games"},
+			},
+			sent: []string{"NICK derek", "NICK derek_", "PONG :s", "PRIVMSG NickServ :IDENTIFY derek hunter2", "JOIN #games key"},
+			nick: "derek_",
+		},
+		{
+			name: "banned",
+			cfg:  config.IRC{Nick: "derek", Channel: "#games"},
+			replies: map[string][]string{
+				"USER derek 0 * :Derek": {":s 001 derek :Welcome"},
+				"JOIN #games":           {":s 474 derek #games :Cannot join channel (+b)"},
+			},
+			wantErr: "unable to join irc channel #games: Cannot join channel (+b)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			standIn := newIRCStandIn(t, tt.replies)
+			tt.cfg.Server = standIn.Addr().String()
+			c, err := NewIRCClient(tt.cfg, noSubscriber{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.Connect()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one about %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Disconnect()
+
+			if got := c.currentNick(); got != tt.nick {
+				t.Errorf("got nick %q, want %q", got, tt.nick)
+			}
+
+			// the greeting is the last thing sent while connecting
+			waitForIRC(t, standIn, "PRIVMSG #games :Engaging Maximum Derek!")
+			got := standIn.received()
+			next := 0
+			for _, line := range got {
+				if next < len(tt.sent) && line == tt.sent[next] {
+					next++
+				}
+			}
+			if next != len(tt.sent) {
+				t.Errorf("sent %q, want %q in that order", got, tt.sent)
+			}
+		})
+	}
+}
+
+func waitForIRC(t *testing.T, standIn *ircStandIn, line string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, l := range standIn.received() {
+			if l == line {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %q, got %q", line, standIn.received())
+}
+
+func TestIRCConnectFailureKeepsTrying(t *testing.T) {
+	// a port nothing is listening on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	c, err := NewIRCClient(config.IRC{Server: addr, Nick: "derek", Channel: "#games"}, noSubscriber{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect()
+	if err == nil || !strings.Contains(err.Error(), "retrying in the background") {
+		t.Fatalf("got error %v, want the connection to be retried", err)
+	}
+	err = c.SendMessages(itemSends(1, 1))
+	if err == nil {
+		t.Fatal("sent without a connection")
+	}
+
+	// the reconnect loop stops with the client
+	done := make(chan struct{})
+	go func() {
+		_ = c.Disconnect()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Disconnect didn't stop the reconnect loop")
+	}
+}
@@ -0,0 +1,262 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+const (
+	matrixTimeout    = 10 * time.Second
+	matrixClientPath = "/_matrix/client/v3"
+)
+
+var (
+	// ansiToHTML turns the colours of the color display mode into the ones Matrix clients show, the item
+	// colours are the same as the embed ones
+	ansiToHTML = map[string]string{
+		ColorGold:    "#d4a72c",
+		ColorWhite:   fmt.Sprintf("#%06x", EmbedColorNormal),
+		ColorMagenta: fmt.Sprintf("#%06x", EmbedColorProgression),
+		ColorBlue:    fmt.Sprintf("#%06x", EmbedColorHelpful),
+		ColorRed:     fmt.Sprintf("#%06x", EmbedColorTrap),
+		ColorTeal:    "#2aa198",
+		ColorGreen:   "#3fb950",
+	}
+)
+
+// MatrixClient posts announcements to a Matrix room through the client-server API
+type MatrixClient struct {
+	http       *http.Client
+	homeserver string
+	token      string
+	room       string
+	// roomID is what the room alias resolved to when it was joined
+	roomID string
+	// session and txn make the transaction ids of the bot's own notices, the session keeps them from clashing
+	// with an earlier run
+	session string
+	txn     atomic.Uint64
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+type matrixJoinResponse struct {
+	RoomID string `json:"room_id"`
+}
+
+type matrixWhoAmIResponse struct {
+	UserID string `json:"user_id"`
+}
+
+type matrixError struct {
+	ErrCode      string `json:"errcode"`
+	Error        string `json:"error"`
+	RetryAfterMS int    `json:"retry_after_ms"`
+}
+
+// NewMatrixClient builds the Matrix sink and subscribes it to the queue
+func NewMatrixClient(cfg config.Matrix, subscriber queue.Subscriber) (*MatrixClient, error) {
+	if cfg.Homeserver == "" || cfg.Room == "" {
+		return nil, fmt.Errorf("matrix needs a homeserver and a room to post to")
+	}
+
+	c := &MatrixClient{
+		http:       &http.Client{Timeout: matrixTimeout},
+		homeserver: strings.TrimSuffix(cfg.Homeserver, "/"),
+		token:      cfg.AccessToken,
+		room:       cfg.Room,
+		session:    fmt.Sprintf("derek-%d", time.Now().UnixNano()),
+	}
+
+	subscriber.RegisterMessageListener(c.Name(), c.SendMessages)
+	return c, nil
+}
+
+func (m *MatrixClient) Name() string {
+	return "matrix"
+}
+
+// Connect joins the room and says hello. When it fails the room is joined again before the next batch is sent.
+func (m *MatrixClient) Connect() error {
+	err := m.join()
+	if err != nil {
+		return err
+	}
+
+	return m.send(m.nextTxnID(), matrixMessage{MsgType: "m.notice", Body: "Engaging Maximum Derek!"})
+}
+
+// join checks the access token and joins the room. Joining a room the bot is already in is fine.
+func (m *MatrixClient) join() error {
+	who := matrixWhoAmIResponse{}
+	err := m.do(http.MethodGet, "/account/whoami", nil, &who)
+	if err != nil {
+		return err
+	}
+
+	joined := matrixJoinResponse{}
+	err = m.do(http.MethodPost, "/join/"+url.PathEscape(m.room), struct{}{}, &joined)
+	if err != nil {
+		return fmt.Errorf("unable to join matrix room %s: %w", m.room, err)
+	}
+	m.roomID = joined.RoomID
+	fmt.Printf("Joined matrix room %s as %s\n", m.room, who.UserID)
+
+	return nil
+}
+
+func (m *MatrixClient) SignOff() error {
+	return m.send(m.nextTxnID(), matrixMessage{MsgType: "m.notice", Body: "Derek signing off"})
+}
+
+func (m *MatrixClient) Disconnect() error {
+	m.http.CloseIdleConnections()
+	return nil
+}
+
+// SendMessages posts every message as its own event. The transaction id only depends on the message, so when the
+// queue retries a batch, even after a restart, the homeserver drops the ones that already went through.
+func (m *MatrixClient) SendMessages(msgs []queue.BroadcastMessage) error {
+	if m.roomID == "" {
+		err := m.join()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, msg := range msgs {
+		err := m.send(m.txnIDFor(msg), matrixMessageFor(msg))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// txnIDFor is built from what the queue keeps on disk for the message. The time is in there as well as the
+// sequence number because numbering starts again from one after a restart when the queue is in memory only.
+func (m *MatrixClient) txnIDFor(msg queue.BroadcastMessage) string {
+	if msg.Seq == 0 {
+		return m.nextTxnID()
+	}
+
+	return fmt.Sprintf("derek-%s-%d-%d", m.roomID, msg.Seq, msg.Time.UnixNano())
+}
+
+// nextTxnID is for messages that don't come from the queue, they are never retried
+func (m *MatrixClient) nextTxnID() string {
+	return fmt.Sprintf("%s-n%d", m.session, m.txn.Add(1))
+}
+
+func matrixMessageFor(msg queue.BroadcastMessage) matrixMessage {
+	parts := layout(msg, msg.Sender == msg.Receiver)
+	return matrixMessage{
+		MsgType:       "m.notice",
+		Body:          renderPlain(parts),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: renderHTML(parts),
+	}
+}
+
+// renderHTML colours the parts the same way the color display mode does
+func renderHTML(parts []textPart) string {
+	sb := strings.Builder{}
+	for _, p := range parts {
+		text := strings.ReplaceAll(html.EscapeString(decorate(p)), "\n", "<br>")
+
+		c, ok := ansiToHTML[partColor(p)]
+		if !ok {
+			sb.WriteString(text)
+			continue
+		}
+		sb.WriteString(fmt.Sprintf(`<font color="%s" data-mx-color="%s">%s</font>`, c, c, text))
+	}
+
+	return sb.String()
+}
+
+func (m *MatrixClient) send(txnID string, msg matrixMessage) error {
+	if m.roomID == "" {
+		return fmt.Errorf("not in matrix room %s", m.room)
+	}
+
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%s", url.PathEscape(m.roomID), url.PathEscape(txnID))
+	err := m.do(http.MethodPut, path, msg, nil)
+	if err != nil {
+		return fmt.Errorf("unable to send matrix message: %w", err)
+	}
+
+	return nil
+}
+
+// do makes a client-server API call, turning Matrix's error bodies into errors
+func (m *MatrixClient) do(method string, path string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("unable to marshal matrix request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, m.homeserver+matrixClientPath+path, body)
+	if err != nil {
+		return fmt.Errorf("unable to build matrix request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := m.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read matrix response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		merr := matrixError{}
+		_ = json.Unmarshal(b, &merr)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return fmt.Errorf("matrix is rate limiting, retry after %dms", merr.RetryAfterMS)
+		}
+		if merr.ErrCode != "" {
+			return fmt.Errorf("matrix answered %s: %s %s", resp.Status, merr.ErrCode, merr.Error)
+		}
+		return fmt.Errorf("matrix answered %s: %s", resp.Status, b)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(b, out)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshal matrix response: %w", err)
+	}
+
+	return nil
+}
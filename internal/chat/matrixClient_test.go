@@ -0,0 +1,240 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+// matrixStandIn is a homeserver that keeps every call made to it
+type matrixStandIn struct {
+	*httptest.Server
+	lock  sync.Mutex
+	calls []string
+	// txnIDs and sent are the transaction ids and bodies of the messages, in the order they arrived
+	txnIDs []string
+	sent   []matrixMessage
+	// fail makes the sends with these numbers, counting from 1, fail
+	fail map[int]bool
+	// down makes every call fail
+	down bool
+}
+
+func newMatrixStandIn(t *testing.T) *matrixStandIn {
+	s := &matrixStandIn{fail: map[int]bool{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer syt_1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"who are you"}`))
+			return
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		p := strings.TrimPrefix(r.URL.Path, matrixClientPath)
+		s.calls = append(s.calls, r.Method+" "+p)
+
+		switch {
+		case r.Method == http.MethodGet && p == "/account/whoami":
+			_, _ = w.Write([]byte(`{"user_id":"@derek:example.org"}`))
+		case r.Method == http.MethodPost && strings.HasPrefix(p, "/join/"):
+			_, _ = w.Write([]byte(`{"room_id":"!games:example.org"}`))
+		case r.Method == http.MethodPut && strings.HasPrefix(p, "/rooms/!games:example.org/send/m.room.message/"):
+			msg := matrixMessage{}
+			err := json.NewDecoder(r.Body).Decode(&msg)
+			if err != nil {
+				t.Errorf("sent body isn't a matrix message: %s", err)
+			}
+			s.txnIDs = append(s.txnIDs, path.Base(p))
+			s.sent = append(s.sent, msg)
+
+			if s.fail[len(s.sent)] {
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","retry_after_ms":2000}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"event_id":"$1"}`))
+		default:
+			t.Errorf("unexpected call %s %s", r.Method, p)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func connectMatrix(t *testing.T, standIn *matrixStandIn) *MatrixClient {
+	t.Helper()
+	c, err := NewMatrixClient(config.Matrix{Homeserver: standIn.URL + "/", AccessToken: "syt_1", Room: "#games:example.org"}, noSubscriber{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestMatrixConnect(t *testing.T) {
+	standIn := newMatrixStandIn(t)
+	connectMatrix(t, standIn)
+
+	want := []string{
+		"GET /account/whoami",
+		"POST /join/#games:example.org",
+		"PUT /rooms/!games:example.org/send/m.room.message/" + standIn.txnIDs[0],
+	}
+	if len(standIn.calls) != len(want) {
+		t.Fatalf("got calls %q, want %q", standIn.calls, want)
+	}
+	for i := range want {
+		if standIn.calls[i] != want[i] {
+			t.Errorf("call %d is %q, want %q", i, standIn.calls[i], want[i])
+		}
+	}
+	if standIn.sent[0].MsgType != "m.notice" {
+		t.Errorf("greeting is a %s, want a notice", standIn.sent[0].MsgType)
+	}
+}
+
+func TestMatrixConnectBadToken(t *testing.T) {
+	standIn := newMatrixStandIn(t)
+	c, err := NewMatrixClient(config.Matrix{Homeserver: standIn.URL, AccessToken: "nope", Room: "#games:example.org"}, noSubscriber{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect()
+	if err == nil || !strings.Contains(err.Error(), "M_UNKNOWN_TOKEN") {
+		t.Fatalf("got error %v, want the homeserver's", err)
+	}
+}
+
+func TestMatrixJoinsOnceTheHomeserverIsBack(t *testing.T) {
+	standIn := newMatrixStandIn(t)
+	standIn.down = true
+	c, err := NewMatrixClient(config.Matrix{Homeserver: standIn.URL, AccessToken: "syt_1", Room: "#games:example.org"}, noSubscriber{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect()
+	if err == nil {
+		t.Fatal("connected to a homeserver that is down")
+	}
+	err = c.SendMessages(itemSends(1, 1))
+	if err == nil {
+		t.Fatal("sent to a homeserver that is down")
+	}
+
+	standIn.down = false
+	err = c.SendMessages(itemSends(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"GET /account/whoami", "POST /join/#games:example.org"}
+	if len(standIn.calls) != 3 || standIn.calls[0] != want[0] || standIn.calls[1] != want[1] {
+		t.Errorf("got calls %q, want %q then the message", standIn.calls, want)
+	}
+}
+
+func TestMatrixRetryReusesTxnIDs(t *testing.T) {
+	standIn := newMatrixStandIn(t)
+	c := connectMatrix(t, standIn)
+
+	batch := itemSends(1, 3)
+	for i := range batch {
+		batch[i].Time = time.Date(2026, 3, 14, 12, 0, 0, i, time.UTC)
+	}
+
+	// the greeting was the first send, the second message of the batch is the third
+	standIn.fail[3] = true
+	err := c.SendMessages(batch)
+	if err == nil || !strings.Contains(err.Error(), "retry after 2000ms") {
+		t.Fatalf("got error %v, want the rate limit", err)
+	}
+	err = c.SendMessages(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and once more after a restart, with the batch reloaded from the store
+	c = connectMatrix(t, standIn)
+	err = c.SendMessages(batch[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// greeting, 1, 2 failed, then the retry 1, 2, 3, then the greeting and 3 after the restart
+	ids := standIn.txnIDs
+	if len(ids) != 8 {
+		t.Fatalf("got %d sends, want 8", len(ids))
+	}
+	first, retry, restart := ids[1:3], ids[3:6], ids[7]
+	if first[0] != retry[0] || first[1] != retry[1] {
+		t.Errorf("retry sent %q, want the ids of the first go %q", retry[:2], first)
+	}
+	if retry[2] != restart {
+		t.Errorf("after a restart the message went as %q, want %q", restart, retry[2])
+	}
+	if retry[0] == retry[1] || retry[1] == retry[2] {
+		t.Errorf("messages share a transaction id: %q", retry)
+	}
+	if ids[0] == ids[6] {
+		t.Errorf("both greetings went as %q, the second one would be dropped", ids[0])
+	}
+
+	// the same number from a queue that started again in memory is a new message
+	again := batch[0]
+	again.Time = again.Time.Add(time.Hour)
+	if c.txnIDFor(again) == retry[0] {
+		t.Error("a reused sequence number from a later run gets the old transaction id")
+	}
+}
+
+func TestMatrixFormattedBodyIsEscaped(t *testing.T) {
+	standIn := newMatrixStandIn(t)
+	c := connectMatrix(t, standIn)
+
+	msg := itemSends(1, 1)[0]
+	msg.Sender = "<script>"
+	msg.Item = `Sword & "Shield"`
+	msg.Location = "Chest\nRoom"
+	err := c.SendMessages([]queue.BroadcastMessage{msg})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := standIn.sent[len(standIn.sent)-1]
+	if sent.Format != "org.matrix.custom.html" {
+		t.Errorf("got format %q", sent.Format)
+	}
+	for _, want := range []string{"&lt;script&gt;", "Sword &amp; &#34;Shield&#34;", "Chest<br>Room", `data-mx-color="`} {
+		if !strings.Contains(sent.FormattedBody, want) {
+			t.Errorf("formatted body %q doesn't have %q", sent.FormattedBody, want)
+		}
+	}
+	if strings.Contains(sent.FormattedBody, "<script>") {
+		t.Errorf("formatted body %q lets the sender's name through as html", sent.FormattedBody)
+	}
+	if !strings.Contains(sent.Body, "<script>") || !strings.Contains(sent.Body, `Sword & "Shield"`) {
+		t.Errorf("plain body %q was escaped", sent.Body)
+	}
+}
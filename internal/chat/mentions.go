@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+const mentionHint = "hint"
+
+type mentionRules struct {
+	users   map[string]string
+	pingOn  importanceMatcher
+	neverOn importanceMatcher
+	hints   bool
+}
+
+func newMentionRules(cfg config.Mentions) (mentionRules, error) {
+	r := mentionRules{
+		users: cfg.Users,
+	}
+
+	var flags []string
+	for _, p := range cfg.PingOn {
+		if strings.ToLower(p) == mentionHint {
+			r.hints = true
+			continue
+		}
+		flags = append(flags, p)
+	}
+
+	var err error
+	r.pingOn, err = newImportanceMatcher(flags)
+	if err != nil {
+		return r, fmt.Errorf("invalid mention ping_on: %w", err)
+	}
+
+	r.neverOn, err = newImportanceMatcher(cfg.NeverOn)
+	if err != nil {
+		return r, fmt.Errorf("invalid mention never_on: %w", err)
+	}
+
+	return r, nil
+}
+
+// mentionFor returns the Discord user to ping for a message, if anyone should be pinged at all
+func (r mentionRules) mentionFor(msg queue.BroadcastMessage, isSelfFind bool) (string, bool) {
+	userID, ok := r.users[msg.Receiver]
+	if !ok {
+		return "", false
+	}
+
+	if r.neverOn.matches(msg.Importance) {
+		return "", false
+	}
+
+	switch msg.Type {
+	case queue.EventHint:
+		// a hint being found is only a follow up, the ping went out when it was first hinted
+		return userID, r.hints && !msg.Found
+	case queue.EventItemSend, queue.EventItemCheat, "":
+		// nobody needs telling about an item they just picked up themselves
+		if isSelfFind && msg.Type != queue.EventItemCheat {
+			return "", false
+		}
+		return userID, r.pingOn.matches(msg.Importance)
+	default:
+		return "", false
+	}
+}
+
+// allowedMentions only lets the bot ping the given users, never @everyone or roles
+func allowedMentions(users ...string) *discordgo.MessageAllowedMentions {
+	return &discordgo.MessageAllowedMentions{
+		Parse: []discordgo.AllowedMentionType{},
+		Users: users,
+	}
+}
@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+// route sends the messages that match every one of its criteria to its channels. A criterion that
+// isn't set matches anything.
+type route struct {
+	channels   []string
+	events     map[string]bool
+	importance *importanceMatcher
+	senders    map[string]bool
+	receivers  map[string]bool
+	games      map[string]bool
+	rooms      map[string]bool
+}
+
+func newRoutes(cfg []config.Route) ([]route, error) {
+	routes := make([]route, 0, len(cfg))
+	for i, r := range cfg {
+		channels := r.Channels
+		if r.ChannelID != "" {
+			channels = append([]string{r.ChannelID}, channels...)
+		}
+		if len(channels) == 0 {
+			return nil, fmt.Errorf("route %d has no channel", i)
+		}
+
+		rt := route{
+			channels:  channels,
+			events:    toSet(r.Events, true),
+			senders:   toSet(r.Senders, false),
+			receivers: toSet(r.Receivers, false),
+			games:     toSet(r.Games, false),
+			rooms:     toSet(r.Rooms, false),
+		}
+
+		if len(r.Importance) > 0 {
+			m, err := newImportanceMatcher(r.Importance)
+			if err != nil {
+				return nil, fmt.Errorf("invalid importance in route %d: %w", i, err)
+			}
+			rt.importance = &m
+		}
+
+		routes = append(routes, rt)
+	}
+
+	return routes, nil
+}
+
+// toSet builds a lookup for a criterion, nil when it isn't set
+func toSet(values []string, fold bool) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		if fold {
+			v = strings.ToLower(v)
+		}
+		set[v] = true
+	}
+
+	return set
+}
+
+func matchesAny(set map[string]bool, values ...string) bool {
+	if set == nil {
+		return true
+	}
+
+	for _, v := range values {
+		if v != "" && set[v] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r route) matches(msg queue.BroadcastMessage) bool {
+	eventType := msg.Type
+	if eventType == "" {
+		eventType = queue.EventItemSend
+	}
+
+	if !matchesAny(r.events, strings.ToLower(string(eventType))) {
+		return false
+	}
+
+	// only item events have an importance to match on
+	if r.importance != nil && (!isItemEvent(msg.Type) || !r.importance.matches(msg.Importance)) {
+		return false
+	}
+
+	// a player event such as a join or chat counts its player as the sender
+	return matchesAny(r.senders, msg.Sender, msg.Player) &&
+		matchesAny(r.receivers, msg.Receiver) &&
+		matchesAny(r.games, msg.SenderGame, msg.ReceiverGame) &&
+		matchesAny(r.rooms, msg.Room)
+}
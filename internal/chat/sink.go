@@ -0,0 +1,15 @@
+package chat
+
+import "github.com/civilrights3/go-derek-go/internal/queue"
+
+// Sink is a chat service announcements are posted to. Every sink subscribes itself to the queue when it is built.
+type Sink interface {
+	// Name is the name the sink is registered with the queue under
+	Name() string
+	Connect() error
+	// SendMessages posts a batch of announcements, it is the sink's queue listener
+	SendMessages(msgs []queue.BroadcastMessage) error
+	// SignOff lets the channels know the bot is going away
+	SignOff() error
+	Disconnect() error
+}
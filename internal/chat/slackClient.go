@@ -0,0 +1,275 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+const (
+	// maxSlackAttachments keeps a post well inside what Slack renders without collapsing it
+	maxSlackAttachments = 20
+	slackTimeout        = 10 * time.Second
+)
+
+// SlackClient posts announcements to a Slack channel, through an incoming webhook or the Web API
+type SlackClient struct {
+	http       *http.Client
+	webhookURL string
+	baseURL    string
+	token      string
+	channelID  string
+	// posted is the last message that made it to Slack, so a batch the queue sends again after a later post
+	// failed doesn't repeat the posts that went through
+	posted uint64
+}
+
+type slackPayload struct {
+	Channel string `json:"channel,omitempty"`
+	// Text is what notifications show, the attachments are what the channel shows
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+// slackAttachment wraps the blocks of one announcement so it gets a coloured bar down the side
+type slackAttachment struct {
+	Color    string       `json:"color"`
+	Fallback string       `json:"fallback"`
+	Blocks   []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// NewSlackClient builds the Slack sink and subscribes it to the queue
+func NewSlackClient(cfg config.Slack, subscriber queue.Subscriber) (*SlackClient, error) {
+	if cfg.WebhookURL == "" && cfg.ChannelID == "" {
+		return nil, fmt.Errorf("slack needs either a webhook url or a channel id to post to")
+	}
+
+	c := &SlackClient{
+		http:       &http.Client{Timeout: slackTimeout},
+		webhookURL: cfg.WebhookURL,
+		baseURL:    strings.TrimSuffix(cfg.BaseURL, "/"),
+		token:      cfg.Token,
+		channelID:  cfg.ChannelID,
+	}
+
+	subscriber.RegisterMessageListener(c.Name(), c.SendMessages)
+	return c, nil
+}
+
+func (s *SlackClient) Name() string {
+	return "slack"
+}
+
+// Connect says hello, which also checks the webhook or token works before anything is queued for it
+func (s *SlackClient) Connect() error {
+	return s.post(slackPayload{Text: "Engaging Maximum Derek!"})
+}
+
+func (s *SlackClient) SignOff() error {
+	return s.post(slackPayload{Text: "Derek signing off"})
+}
+
+func (s *SlackClient) Disconnect() error {
+	s.http.CloseIdleConnections()
+	return nil
+}
+
+// SendMessages posts a batch of messages, as many to a post as Slack comfortably shows
+func (s *SlackClient) SendMessages(msgs []queue.BroadcastMessage) error {
+	msgs = unsent(msgs, s.posted)
+	for start := 0; start < len(msgs); start += maxSlackAttachments {
+		end := start + maxSlackAttachments
+		if end > len(msgs) {
+			end = len(msgs)
+		}
+
+		payload := slackPayload{}
+		for _, msg := range msgs[start:end] {
+			payload.Attachments = append(payload.Attachments, slackAttachmentFor(msg))
+		}
+
+		payload.Text = payload.Attachments[0].Fallback
+		if len(payload.Attachments) > 1 {
+			payload.Text = fmt.Sprintf("%d new announcements", len(payload.Attachments))
+		}
+
+		err := s.post(payload)
+		if err != nil {
+			return err
+		}
+		if msgs[end-1].Seq > s.posted {
+			s.posted = msgs[end-1].Seq
+		}
+	}
+
+	return nil
+}
+
+// unsent drops the messages up to the last one posted. The queue hands a listener its messages in order, so
+// anything at or below it is a retry of something already posted.
+func unsent(msgs []queue.BroadcastMessage, posted uint64) []queue.BroadcastMessage {
+	for i, msg := range msgs {
+		if msg.Seq > posted || msg.Seq == 0 {
+			return msgs[i:]
+		}
+	}
+
+	return nil
+}
+
+func slackAttachmentFor(msg queue.BroadcastMessage) slackAttachment {
+	selfFind := msg.Sender == msg.Receiver
+	parts := layout(msg, selfFind)
+
+	color := EmbedColorNeutral
+	if isItemEvent(msg.Type) {
+		color = embedColor(msg.Importance)
+	}
+
+	a := slackAttachment{
+		Color:    fmt.Sprintf("#%06x", color),
+		Fallback: renderPlain(parts),
+		Blocks: []slackBlock{
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: renderMrkdwn(parts)}},
+		},
+	}
+
+	// the item belongs to the receiver's game, the same as the embed footer
+	if isItemEvent(msg.Type) && msg.ReceiverGame != "" {
+		a.Blocks = append(a.Blocks, slackBlock{
+			Type:     "context",
+			Elements: []slackText{{Type: "mrkdwn", Text: mrkdwnEscape(msg.ReceiverGame)}},
+		})
+	}
+
+	return a
+}
+
+var mrkdwnEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// mrkdwnEscape stops names from the room being read as links or mentions
+func mrkdwnEscape(s string) string {
+	return mrkdwnEscaper.Replace(s)
+}
+
+// renderMrkdwn styles the parts with Slack's markup, which has no colours. Progression items are bold and
+// traps struck through so they stand out the way their colour does in the other display modes.
+func renderMrkdwn(parts []textPart) string {
+	sb := strings.Builder{}
+	for _, p := range parts {
+		text := mrkdwnEscape(p.text)
+
+		switch p.kind {
+		case partSender, partReceiver:
+			sb.WriteString(wrapMrkdwn(text, "*"))
+		case partItem:
+			switch {
+			case p.importance&queue.ItemTrap != 0:
+				sb.WriteString(wrapMrkdwn(text, "~"))
+			case p.importance&queue.ItemProgression != 0:
+				sb.WriteString(wrapMrkdwn(text, "*"))
+			default:
+				sb.WriteString(wrapMrkdwn(strings.ReplaceAll(text, "`", "'"), "`"))
+			}
+		case partLocation, partEntrance:
+			sb.WriteString(wrapMrkdwn(text, "_"))
+		case partRoom:
+			sb.WriteString(fmt.Sprintf("[%s]", text))
+		default:
+			sb.WriteString(text)
+		}
+	}
+
+	return sb.String()
+}
+
+// wrapMrkdwn puts the markers inside any surrounding spaces, Slack ignores markup that starts or ends on one
+func wrapMrkdwn(text string, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+
+	start := strings.Index(text, trimmed)
+	return text[:start] + marker + trimmed + marker + text[start+len(trimmed):]
+}
+
+// post sends to the webhook when there is one, otherwise to chat.postMessage
+func (s *SlackClient) post(payload slackPayload) error {
+	url := s.webhookURL
+	if url == "" {
+		url = s.baseURL + "/chat.postMessage"
+		payload.Channel = s.channelID
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to marshal slack message: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("unable to build slack request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if s.webhookURL == "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to send slack message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read slack response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("slack is rate limiting, retry after %ss", resp.Header.Get("Retry-After"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack answered %s: %s", resp.Status, body)
+	}
+
+	// webhooks answer with a bare ok, the Web API with a JSON body
+	if s.webhookURL != "" {
+		return nil
+	}
+
+	out := slackResponse{}
+	err = json.Unmarshal(body, &out)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshal slack response: %w", err)
+	}
+	if !out.OK {
+		return fmt.Errorf("slack refused the message: %s", out.Error)
+	}
+
+	return nil
+}
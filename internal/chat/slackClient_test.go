@@ -0,0 +1,279 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+type noSubscriber struct{}
+
+func (noSubscriber) RegisterMessageListener(string, queue.MessageListener) {}
+
+// slackStandIn records what is posted to it and answers with whatever the test sets
+type slackStandIn struct {
+	*httptest.Server
+	lock     sync.Mutex
+	requests []*http.Request
+	payloads []slackPayload
+	// answer writes the response, nil answers ok
+	answer func(w http.ResponseWriter, n int)
+}
+
+func newSlackStandIn(t *testing.T) *slackStandIn {
+	s := &slackStandIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := slackPayload{}
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		if err != nil {
+			t.Errorf("posted body isn't a slack payload: %s", err)
+		}
+
+		s.lock.Lock()
+		s.requests = append(s.requests, r)
+		s.payloads = append(s.payloads, payload)
+		n := len(s.payloads)
+		answer := s.answer
+		s.lock.Unlock()
+
+		if answer != nil {
+			answer(w, n)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func itemSends(first uint64, n int) []queue.BroadcastMessage {
+	msgs := make([]queue.BroadcastMessage, 0, n)
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, queue.BroadcastMessage{
+			Seq:        first + uint64(i),
+			Type:       queue.EventItemSend,
+			Sender:     "Link",
+			Receiver:   "Samus",
+			Item:       "Hookshot",
+			Location:   "Chest",
+			Importance: queue.ItemProgression,
+		})
+	}
+
+	return msgs
+}
+
+func TestSlackWebhook(t *testing.T) {
+	standIn := newSlackStandIn(t)
+	c, err := NewSlackClient(config.Slack{WebhookURL: standIn.URL + "/services/T/B/X", Token: "unused"}, noSubscriber{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a webhook answers with a bare ok rather than JSON
+	standIn.answer = func(w http.ResponseWriter, n int) {
+		_, _ = w.Write([]byte("ok"))
+	}
+	err = c.SendMessages(itemSends(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := standIn.requests[0]
+	if r.URL.Path != "/services/T/B/X" {
+		t.Errorf("posted to %s, want the webhook", r.URL.Path)
+	}
+	if r.Header.Get("Authorization") != "" {
+		t.Error("webhook post carries a token")
+	}
+	if standIn.payloads[0].Channel != "" {
+		t.Error("webhook post names a channel, the webhook decides that")
+	}
+}
+
+func TestSlackPostMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  func(w http.ResponseWriter, n int)
+		wantErr string
+	}{
+		{
+			name: "ok",
+		},
+		{
+			name: "refused",
+			answer: func(w http.ResponseWriter, n int) {
+				_, _ = w.Write([]byte(`{"ok":false,"error":"channel_not_found"}`))
+			},
+			wantErr: "channel_not_found",
+		},
+		{
+			name: "rate limited",
+			answer: func(w http.ResponseWriter, n int) {
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			wantErr: "retry after 30s",
+		},
+		{
+			name: "server error",
+			answer: func(w http.ResponseWriter, n int) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantErr: "500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			standIn := newSlackStandIn(t)
+			standIn.answer = tt.answer
+			c, err := NewSlackClient(config.Slack{Token: "xoxb-1", ChannelID: "C1", BaseURL: standIn.URL + "/"}, noSubscriber{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.SendMessages(itemSends(1, 1))
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want one about %s", err, tt.wantErr)
+			}
+
+			r := standIn.requests[0]
+			if r.URL.Path != "/chat.postMessage" {
+				t.Errorf("posted to %s, want /chat.postMessage", r.URL.Path)
+			}
+			if got := r.Header.Get("Authorization"); got != "Bearer xoxb-1" {
+				t.Errorf("got authorization %q", got)
+			}
+			if standIn.payloads[0].Channel != "C1" {
+				t.Errorf("posted to channel %q, want C1", standIn.payloads[0].Channel)
+			}
+		})
+	}
+}
+
+func TestSlackSplitsIntoPosts(t *testing.T) {
+	standIn := newSlackStandIn(t)
+	c, err := NewSlackClient(config.Slack{Token: "xoxb-1", ChannelID: "C1", BaseURL: standIn.URL}, noSubscriber{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SendMessages(itemSends(1, 2*maxSlackAttachments+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{maxSlackAttachments, maxSlackAttachments, 1}
+	if len(standIn.payloads) != len(want) {
+		t.Fatalf("got %d posts, want %d", len(standIn.payloads), len(want))
+	}
+	for i, p := range standIn.payloads {
+		if len(p.Attachments) != want[i] {
+			t.Errorf("post %d has %d attachments, want %d", i, len(p.Attachments), want[i])
+		}
+	}
+	if !strings.HasPrefix(standIn.payloads[2].Text, "[Link] sent <Hookshot>") {
+		t.Errorf("a single announcement notifies with %q, want the announcement", standIn.payloads[2].Text)
+	}
+}
+
+func TestSlackRetryOnlyPostsTheRest(t *testing.T) {
+	standIn := newSlackStandIn(t)
+	// the second post fails once
+	standIn.answer = func(w http.ResponseWriter, n int) {
+		if n == 2 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}
+	c, err := NewSlackClient(config.Slack{Token: "xoxb-1", ChannelID: "C1", BaseURL: standIn.URL}, noSubscriber{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := itemSends(1, maxSlackAttachments+5)
+	err = c.SendMessages(batch)
+	if err == nil {
+		t.Fatal("the failed post wasn't reported")
+	}
+
+	// the queue sends the whole batch again
+	err = c.SendMessages(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{maxSlackAttachments, 5, 5}
+	if len(standIn.payloads) != len(want) {
+		t.Fatalf("got %d posts, want %d", len(standIn.payloads), len(want))
+	}
+	for i, p := range standIn.payloads {
+		if len(p.Attachments) != want[i] {
+			t.Errorf("post %d has %d attachments, want %d", i, len(p.Attachments), want[i])
+		}
+	}
+}
+
+func TestRenderMrkdwn(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []textPart
+		want  string
+	}{
+		{
+			name:  "names are bold",
+			parts: []textPart{senderPart("Link"), plainPart(" found it")},
+			want:  "*Link* found it",
+		},
+		{
+			name:  "links and mentions are escaped",
+			parts: []textPart{senderPart("<!channel>"), plainPart(" & <@U1>")},
+			want:  "*&lt;!channel&gt;* &amp; &lt;@U1&gt;",
+		},
+		{
+			name:  "markers stay inside the spaces",
+			parts: []textPart{{kind: partSender, text: " Link ", bare: true}, plainPart("said")},
+			want:  " *Link* said",
+		},
+		{
+			name: "item importance",
+			parts: []textPart{
+				itemPart("Hookshot", queue.ItemProgression), plainPart(" "),
+				itemPart("Ice Trap", queue.ItemTrap), plainPart(" "),
+				itemPart("Rupee`s", queue.ItemNormal),
+			},
+			want: "*Hookshot* ~Ice Trap~ `Rupee's`",
+		},
+		{
+			name:  "locations and entrances",
+			parts: []textPart{locationPart("Chest"), plainPart(" via "), entrancePart("Cave")},
+			want:  "_Chest_ via _Cave_",
+		},
+		{
+			name:  "empty parts aren't marked",
+			parts: []textPart{senderPart(""), plainPart("x")},
+			want:  "x",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderMrkdwn(tt.parts)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+// importanceOrder is the order item counts are listed in, most interesting first
+var importanceOrder = []string{"progression", "helpful", "normal", "trap"}
+
+// describeSummary lays out a report as a heading followed by a line per player
+func describeSummary(msg queue.BroadcastMessage) []textPart {
+	heading := "Summary"
+	if msg.Type == queue.EventFinalReport {
+		heading = "Final report, everyone is done!"
+	}
+	parts := []textPart{plainPart(fmt.Sprintf("%s (%d/%d players done)", heading, msg.Count, msg.Total))}
+
+	for _, s := range msg.Summary {
+		parts = append(parts, plainPart("\n"), senderPart(s.Name), plainPart(" "+slotSummary(s)))
+	}
+
+	return parts
+}
+
+func slotSummary(s queue.SlotSummary) string {
+	var stats []string
+	if s.Goal {
+		stats = append(stats, "done")
+	}
+
+	if s.Total > 0 {
+		stats = append(stats, fmt.Sprintf("%d/%d checks", s.Checks, s.Total))
+	} else {
+		stats = append(stats, fmt.Sprintf("%d checks", s.Checks))
+	}
+
+	stats = append(stats, fmt.Sprintf("sent %s", itemCounts(s.Sent)))
+	stats = append(stats, fmt.Sprintf("received %s", itemCounts(s.Received)))
+	if s.Received["trap"] > 0 {
+		stats = append(stats, fmt.Sprintf("%d traps", s.Received["trap"]))
+	}
+	stats = append(stats, fmt.Sprintf("%d hints", s.Hints))
+
+	if s.AverageGap > 0 {
+		stats = append(stats, fmt.Sprintf("a check every %s (longest wait %s)", s.AverageGap.Round(time.Second), s.LongestGap.Round(time.Second)))
+	}
+
+	return strings.Join(stats, ", ")
+}
+
+// itemCounts is the total number of items followed by how many of them were of each importance
+func itemCounts(counts map[string]int) string {
+	total := 0
+	var detail []string
+	for _, name := range importanceOrder {
+		n := counts[name]
+		total += n
+		if n > 0 && name != "normal" && name != "trap" {
+			detail = append(detail, fmt.Sprintf("%d %s", n, name))
+		}
+	}
+
+	if len(detail) == 0 {
+		return fmt.Sprintf("%d", total)
+	}
+
+	return fmt.Sprintf("%d (%s)", total, strings.Join(detail, ", "))
+}
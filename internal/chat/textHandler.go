@@ -2,35 +2,237 @@ package chat
 
 import (
 	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
 	"github.com/civilrights3/go-derek-go/internal/queue"
 )
 
-type textHandler func(message queue.BroadcastMessage, isSelfFind bool) string
+// textHandler builds the full Discord payload for a message in one of the display modes
+type textHandler func(message queue.BroadcastMessage, isSelfFind bool) *discordgo.MessageSend
+
+// partKind is what a piece of an announcement represents, each display mode decorates them differently
+type partKind int
+
+const (
+	partText partKind = iota
+	partSender
+	partReceiver
+	partItem
+	partLocation
+	partEntrance
+	partRoom
+)
+
+type textPart struct {
+	kind       partKind
+	text       string
+	importance queue.ItemImportanceFlag
+	// bare parts already carry the server's punctuation so they are only coloured, never bracketed
+	bare bool
+	// color overrides the colour picked from the kind
+	color string
+}
+
+func plainPart(s string) textPart {
+	return textPart{kind: partText, text: s}
+}
+
+func senderPart(s string) textPart {
+	return textPart{kind: partSender, text: s}
+}
+
+func receiverPart(s string) textPart {
+	return textPart{kind: partReceiver, text: s}
+}
+
+func itemPart(s string, importance queue.ItemImportanceFlag) textPart {
+	return textPart{kind: partItem, text: s, importance: importance}
+}
+
+func locationPart(s string) textPart {
+	return textPart{kind: partLocation, text: s}
+}
+
+func entrancePart(s string) textPart {
+	return textPart{kind: partEntrance, text: s}
+}
+
+var (
+	dataPartKinds = map[queue.TextPartType]partKind{
+		queue.TextPlain:    partText,
+		queue.TextPlayer:   partSender,
+		queue.TextItem:     partItem,
+		queue.TextLocation: partLocation,
+		queue.TextEntrance: partEntrance,
+		queue.TextColor:    partText,
+	}
+
+	namedColors = map[string]string{
+		"red":     ColorRed,
+		"green":   ColorGreen,
+		"yellow":  ColorGold,
+		"blue":    ColorBlue,
+		"magenta": ColorMagenta,
+		"cyan":    ColorTeal,
+		"white":   ColorWhite,
+	}
+)
+
+// fromData converts the server's rendering of an event into parts
+func fromData(data []queue.TextPart) []textPart {
+	parts := make([]textPart, 0, len(data))
+	for _, d := range data {
+		p := textPart{
+			kind:       dataPartKinds[d.Type],
+			text:       d.Text,
+			importance: d.Importance,
+			bare:       true,
+		}
+		if d.Type == queue.TextColor {
+			p.color = namedColors[d.Color]
+		}
 
-func formatPlainMessage(msg queue.BroadcastMessage, isSelfFind bool) string {
-	if isSelfFind {
-		return fmt.Sprintf("[%s] found their <%s> (%s)", msg.Receiver, msg.Item, msg.Location)
+		parts = append(parts, p)
 	}
 
-	return fmt.Sprintf("[%s] sent <%s> to {%s} (%s)", msg.Sender, msg.Item, msg.Receiver, msg.Location)
+	return parts
 }
 
-func formatMonospacedMessage(msg queue.BroadcastMessage, isSelfFind bool) string {
-	if isSelfFind {
-		return fmt.Sprintf("`[%s] found their <%s> (%s)`", msg.Receiver, msg.Item, msg.Location)
+// layout is the full announcement for a message, prefixed with its room when more than one is tracked
+func layout(msg queue.BroadcastMessage, isSelfFind bool) []textPart {
+	parts := describe(msg, isSelfFind)
+	if msg.Room == "" {
+		return parts
 	}
 
-	return fmt.Sprintf("`[%s] sent <%s> to {%s} (%s)`", msg.Sender, msg.Item, msg.Receiver, msg.Location)
+	return append([]textPart{{kind: partRoom, text: msg.Room}, plainPart(" ")}, parts...)
+}
+
+// describe lays out the announcement for a message, independent of how it is displayed
+func describe(msg queue.BroadcastMessage, isSelfFind bool) []textPart {
+	switch msg.Type {
+	case queue.EventItemSend, "":
+		if isSelfFind {
+			return []textPart{senderPart(msg.Receiver), plainPart(" found their "), itemPart(msg.Item, msg.Importance), plainPart(" "), locationPart(msg.Location)}
+		}
+
+		return []textPart{senderPart(msg.Sender), plainPart(" sent "), itemPart(msg.Item, msg.Importance), plainPart(" to "), receiverPart(msg.Receiver), plainPart(" "), locationPart(msg.Location)}
+	case queue.EventItemCheat:
+		return []textPart{receiverPart(msg.Receiver), plainPart(" was given "), itemPart(msg.Item, msg.Importance), plainPart(" by the server")}
+	case queue.EventHint:
+		parts := []textPart{plainPart("Hint: "), receiverPart(msg.Receiver), plainPart("'s "), itemPart(msg.Item, msg.Importance), plainPart(" is at "), locationPart(msg.Location)}
+		if msg.Entrance != "" {
+			parts = append(parts, plainPart(" via "), entrancePart(msg.Entrance))
+		}
+		if isSelfFind {
+			parts = append(parts, plainPart(" in their own world"))
+		} else {
+			parts = append(parts, plainPart(" in "), senderPart(msg.Sender), plainPart("'s world"))
+		}
+		if msg.Found {
+			parts = append(parts, plainPart(" (found)"))
+		}
+		return parts
+	case queue.EventJoin:
+		parts := []textPart{senderPart(msg.Player), plainPart(" joined the room")}
+		if len(msg.Tags) > 0 {
+			parts = append(parts, plainPart(fmt.Sprintf(" (%s)", strings.Join(msg.Tags, ", "))))
+		}
+		return parts
+	case queue.EventPart:
+		return []textPart{senderPart(msg.Player), plainPart(" left the room")}
+	case queue.EventChat:
+		return []textPart{senderPart(msg.Player), plainPart(": " + msg.Message)}
+	case queue.EventServerChat:
+		return []textPart{senderPart("Server"), plainPart(": " + msg.Message)}
+	case queue.EventGoal:
+		parts := []textPart{senderPart(msg.Player), plainPart(" has completed their goal")}
+		if msg.Total > 0 {
+			parts = append(parts, plainPart(fmt.Sprintf(" (%d/%d players done)", msg.Count, msg.Total)))
+		}
+		return parts
+	case queue.EventProgress:
+		if msg.Total == 0 {
+			return []textPart{senderPart(msg.Player), plainPart(fmt.Sprintf(" has checked %d locations", msg.Count))}
+		}
+		return []textPart{senderPart(msg.Player), plainPart(fmt.Sprintf(" hit %d%% checks (%d/%d)", msg.Count*100/msg.Total, msg.Count, msg.Total))}
+	case queue.EventRelease:
+		return []textPart{senderPart(msg.Player), plainPart(" released their remaining items")}
+	case queue.EventCollect:
+		return []textPart{senderPart(msg.Player), plainPart(" collected their remaining items")}
+	case queue.EventCountdown:
+		if msg.Countdown <= 0 {
+			return []textPart{plainPart("GO!")}
+		}
+		return []textPart{plainPart(fmt.Sprintf("Starting in %d...", msg.Countdown))}
+	case queue.EventDigest, queue.EventFinalReport:
+		return describeSummary(msg)
+	case queue.EventTagsChanged:
+		return []textPart{senderPart(msg.Player), plainPart(fmt.Sprintf(" changed their tags to [%s]", strings.Join(msg.Tags, ", ")))}
+	default:
+		if len(msg.Parts) > 0 {
+			return fromData(msg.Parts)
+		}
+		return []textPart{plainPart(msg.Message)}
+	}
+}
+
+// decorate wraps a part in the brackets used by the text display modes
+func decorate(p textPart) string {
+	if p.bare {
+		return p.text
+	}
+
+	switch p.kind {
+	case partSender:
+		return fmt.Sprintf("[%s]", p.text)
+	case partReceiver:
+		return fmt.Sprintf("{%s}", p.text)
+	case partItem:
+		return fmt.Sprintf("<%s>", p.text)
+	case partLocation:
+		return fmt.Sprintf("(%s)", p.text)
+	case partEntrance:
+		return fmt.Sprintf("(%s)", p.text)
+	case partRoom:
+		return fmt.Sprintf("«%s»", p.text)
+	default:
+		return p.text
+	}
+}
+
+func renderPlain(parts []textPart) string {
+	sb := strings.Builder{}
+	for _, p := range parts {
+		sb.WriteString(decorate(p))
+	}
+
+	return sb.String()
+}
+
+func formatPlainMessage(msg queue.BroadcastMessage, isSelfFind bool) *discordgo.MessageSend {
+	return &discordgo.MessageSend{
+		Content: renderPlain(layout(msg, isSelfFind)),
+	}
+}
+
+func formatMonospacedMessage(msg queue.BroadcastMessage, isSelfFind bool) *discordgo.MessageSend {
+	// a stray backtick from chat would end the code span early
+	return &discordgo.MessageSend{
+		Content: fmt.Sprintf("`%s`", strings.ReplaceAll(renderPlain(layout(msg, isSelfFind)), "`", "'")),
+	}
 }
 
 const (
-	ColorNeutral = `[0m`
-	ColorGold    = `[3;33m`
-	ColorWhite   = `[3;37m`
-	ColorMagenta = `[3;35m`
-	ColorBlue    = `[3;34m`
-	ColorRed     = `[3;31m`
-	ColorTeal    = `[3;36m`
+	ColorNeutral = "\x1b[0m"
+	ColorGold    = "\x1b[3;33m"
+	ColorWhite   = "\x1b[3;37m"
+	ColorMagenta = "\x1b[3;35m"
+	ColorBlue    = "\x1b[3;34m"
+	ColorRed     = "\x1b[3;31m"
+	ColorTeal    = "\x1b[3;36m"
+	ColorGreen   = "\x1b[3;32m"
 )
 
 var (
@@ -42,10 +244,40 @@ var (
 	}
 )
 
-func formatColorMessage(msg queue.BroadcastMessage, isSelfFind bool) string {
-	if isSelfFind {
-		return fmt.Sprintf("```ansi\n%s[%s]%s found their %s<%s> %s(%s)\n```", ColorGold, msg.Receiver, ColorNeutral, importanceToColor[msg.Importance], msg.Item, ColorTeal, msg.Location)
+func partColor(p textPart) string {
+	if p.color != "" {
+		return p.color
 	}
 
-	return fmt.Sprintf("```ansi\n%s[%s]%s sent %s<%s>%s to %s{%s} %s(%s)\n```", ColorGold, msg.Sender, ColorNeutral, importanceToColor[msg.Importance], msg.Item, ColorNeutral, ColorGold, msg.Receiver, ColorTeal, msg.Location)
+	switch p.kind {
+	case partSender, partReceiver:
+		return ColorGold
+	case partItem:
+		return importanceToColor[p.importance]
+	case partLocation:
+		return ColorTeal
+	case partEntrance:
+		return ColorBlue
+	case partRoom:
+		return ColorWhite
+	default:
+		return ColorNeutral
+	}
+}
+
+func formatColorMessage(msg queue.BroadcastMessage, isSelfFind bool) *discordgo.MessageSend {
+	sb := strings.Builder{}
+	current := ColorNeutral
+	for _, p := range layout(msg, isSelfFind) {
+		c := partColor(p)
+		if c != current {
+			sb.WriteString(c)
+			current = c
+		}
+		sb.WriteString(strings.ReplaceAll(decorate(p), "```", "'''"))
+	}
+
+	return &discordgo.MessageSend{
+		Content: fmt.Sprintf("```ansi\n%s\n```", sb.String()),
+	}
 }
@@ -6,6 +6,7 @@ const (
 	DisplayPlain      DisplayMode = "plain"
 	DisplayMonospaced DisplayMode = "mono"
 	DisplayColor      DisplayMode = "color"
+	DisplayEmbed      DisplayMode = "embed"
 )
 
 type Chat struct {
@@ -13,10 +14,41 @@ type Chat struct {
 	GuildID     string      `yaml:"guild_id"`
 	ChannelID   string      `yaml:"channel_id"`
 	DisplayMode DisplayMode `yaml:"display_mode"`
+	Mentions    Mentions    `yaml:"mentions"`
+	// Routes send matching messages to other channels, anything no route matches goes to the room or default channel
+	Routes []Route `yaml:"routes"`
+}
+
+// Route matches messages on every criterion that is set. An empty criterion matches anything.
+type Route struct {
+	ChannelID string   `yaml:"channel_id"`
+	Channels  []string `yaml:"channels"`
+	// Events are PrintJSON types such as ItemSend, Hint or Chat
+	Events []string `yaml:"events"`
+	// Importance is item importances, normal, progression, helpful or trap
+	Importance []string `yaml:"importance"`
+	Senders    []string `yaml:"senders"`
+	Receivers  []string `yaml:"receivers"`
+	Games      []string `yaml:"games"`
+	Rooms      []string `yaml:"rooms"`
+}
+
+type Mentions struct {
+	// Users maps Archipelago slot names to Discord user IDs. It is also who may use /hint for a slot.
+	Users map[string]string `yaml:"users"`
+	// PingOn is the item importances (normal, progression, helpful, trap) that ping the receiver, plus hint for hints
+	PingOn []string `yaml:"ping_on"`
+	// NeverOn is the item importances that never ping, even if they also match PingOn
+	NeverOn []string `yaml:"never_on"`
 }
 
 func newDefaultChat() Chat {
 	return Chat{
 		DisplayMode: DisplayPlain,
+		Mentions: Mentions{
+			Users:   map[string]string{},
+			PingOn:  []string{"progression"},
+			NeverOn: []string{"trap"},
+		},
 	}
 }
@@ -3,11 +3,22 @@ package config
 type Config struct {
 	Chat       Chat       `yaml:"chat"`
 	Multiworld Multiworld `yaml:"multiworld"`
+	Queue      Queue      `yaml:"queue"`
+	Summary    Summary    `yaml:"summary"`
+	EventLog   EventLog   `yaml:"event_log"`
+	Slack      Slack      `yaml:"slack"`
+	Matrix     Matrix     `yaml:"matrix"`
+	IRC        IRC        `yaml:"irc"`
 }
 
 func NewDefaultConfig() Config {
 	return Config{
 		Chat:       newDefaultChat(),
 		Multiworld: newDefaultMultiworld(),
+		Queue:      newDefaultQueue(),
+		Summary:    newDefaultSummary(),
+		EventLog:   newDefaultEventLog(),
+		Slack:      newDefaultSlack(),
+		IRC:        newDefaultIRC(),
 	}
 }
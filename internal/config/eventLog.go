@@ -0,0 +1,22 @@
+package config
+
+const (
+	defaultEventLogMaxSizeMB = 10
+	defaultEventLogMaxFiles  = 20
+)
+
+type EventLog struct {
+	// File is where every event is appended as JSON lines, the event log is off when empty
+	File string `yaml:"file,omitempty"`
+	// MaxSizeMB is how big the file gets before it is moved aside with the time in its name
+	MaxSizeMB int64 `yaml:"max_size_mb"`
+	// MaxFiles is how many moved aside files are kept, zero keeps them all
+	MaxFiles int `yaml:"max_files"`
+}
+
+func newDefaultEventLog() EventLog {
+	return EventLog{
+		MaxSizeMB: defaultEventLogMaxSizeMB,
+		MaxFiles:  defaultEventLogMaxFiles,
+	}
+}
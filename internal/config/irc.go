@@ -0,0 +1,44 @@
+package config
+
+import "time"
+
+const (
+	defaultIRCNick       = "derek"
+	defaultIRCFloodDelay = 2 * time.Second
+	defaultIRCFloodBurst = 5
+)
+
+// IRC posts announcements to an IRC channel as well as Discord. It is off unless a server is set.
+type IRC struct {
+	// Server is the host:port to connect to
+	Server string `yaml:"server,omitempty"`
+	TLS    bool   `yaml:"tls"`
+	Nick   string `yaml:"nick"`
+	// Channel is joined on connect, with ChannelKey if it has one
+	Channel    string `yaml:"channel,omitempty"`
+	ChannelKey string `yaml:"channel_key,omitempty"`
+	// Account and Password identify the nick with services, the account is the nick when empty
+	Account  string `yaml:"account,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// PasswordFile is read in place of Password so the password can be kept out of the config
+	PasswordFile string `yaml:"password_file,omitempty"`
+	// SASL logs in with SASL PLAIN while connecting, otherwise the password is sent to NickServ once connected
+	SASL bool `yaml:"sasl"`
+	// FloodDelay is how long each line counts against the server's flood limit, FloodBurst is how many lines
+	// can go out back to back before the bot has to wait
+	FloodDelay time.Duration `yaml:"flood_delay"`
+	FloodBurst int           `yaml:"flood_burst"`
+}
+
+func newDefaultIRC() IRC {
+	return IRC{
+		Nick:       defaultIRCNick,
+		FloodDelay: defaultIRCFloodDelay,
+		FloodBurst: defaultIRCFloodBurst,
+	}
+}
+
+// Enabled is whether there is enough configured to post anything
+func (i IRC) Enabled() bool {
+	return i.Server != ""
+}
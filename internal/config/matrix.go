@@ -0,0 +1,17 @@
+package config
+
+// Matrix posts announcements to a Matrix room as well as Discord. It is off unless an access token is set.
+type Matrix struct {
+	// Homeserver is the base url of the bot account's homeserver, such as https://matrix.org
+	Homeserver  string `yaml:"homeserver,omitempty"`
+	AccessToken string `yaml:"access_token,omitempty"`
+	// AccessTokenFile is read in place of AccessToken so the token can be kept out of the config
+	AccessTokenFile string `yaml:"access_token_file,omitempty"`
+	// Room is the room id or alias to join and post to
+	Room string `yaml:"room,omitempty"`
+}
+
+// Enabled is whether there is enough configured to post anything
+func (m Matrix) Enabled() bool {
+	return m.AccessToken != ""
+}
@@ -1,5 +1,7 @@
 package config
 
+import "fmt"
+
 const (
 	defaultClientID         = "163519839402105"
 	defaultVersion          = "0.5.0"
@@ -13,14 +15,30 @@ type Multiworld struct {
 	ClientID           string `yaml:"client_id,omitempty"`
 	ClientVersion      string `yaml:"client_version,omitempty"`
 	MaxConnectionRetry int    `yaml:"max_connection_retry"`
-	World              World  `yaml:"world,omitempty"`
-	Cache              Cache  `yaml:"cache,omitempty"`
+	// World is a single room, kept for configs from before Rooms existed
+	World World   `yaml:"world,omitempty"`
+	Rooms []World `yaml:"rooms,omitempty"`
+	Cache Cache   `yaml:"cache,omitempty"`
+	// ProgressMilestones are the percentages of a slot's checks that get announced
+	ProgressMilestones []int `yaml:"progress_milestones,omitempty"`
 }
 
 type World struct {
+	// Name tags every message from the room so they can be told apart
+	Name string `yaml:"name,omitempty"`
+	// Server is either a bare host or a full URL such as ws://localhost:38281
 	Server string `yaml:"server,omitempty"`
 	Port   string `yaml:"port,omitempty"`
-	Slot   string `yaml:"slot,omitempty"`
+	// Scheme forces ws or wss, when empty wss is tried first with a fallback to ws
+	Scheme   string `yaml:"scheme,omitempty"`
+	Slot     string `yaml:"slot,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// PasswordFile is read in place of Password so the room password can be kept out of the config
+	PasswordFile string `yaml:"password_file,omitempty"`
+	// ChannelID is the Discord channel the room's announcements go to, the chat channel when empty
+	ChannelID string `yaml:"channel_id,omitempty"`
+	// BridgeChannelID relays chat both ways between this Discord channel and the room
+	BridgeChannelID string `yaml:"bridge_channel_id,omitempty"`
 }
 
 type Cache struct {
@@ -38,5 +56,40 @@ func newDefaultMultiworld() Multiworld {
 		Cache: Cache{
 			Filepath: defaultCacheFilepath,
 		},
+		ProgressMilestones: []int{25, 50, 75, 100},
+	}
+}
+
+// AllRooms is every room to track, falling back to the single World when no rooms are listed
+func (m Multiworld) AllRooms() []World {
+	if len(m.Rooms) == 0 {
+		return []World{m.World}
+	}
+
+	rooms := make([]World, 0, len(m.Rooms))
+	for _, r := range m.Rooms {
+		if r.Server == "" {
+			r.Server = defaultMultiworldServer
+		}
+		rooms = append(rooms, r)
 	}
+
+	return rooms
+}
+
+// CheckRooms makes sure every listed room has a name of its own, since the rooms' channels, bridges and
+// commands are all looked up by name
+func (m Multiworld) CheckRooms() error {
+	seen := make(map[string]bool)
+	for i, r := range m.Rooms {
+		if r.Name == "" && len(m.Rooms) > 1 {
+			return fmt.Errorf("room %d has no name, every room needs one when there are several", i+1)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("room name %s is used more than once", r.Name)
+		}
+		seen[r.Name] = true
+	}
+
+	return nil
 }
@@ -0,0 +1,26 @@
+package config
+
+import "testing"
+
+func TestCheckRooms(t *testing.T) {
+	tests := []struct {
+		name    string
+		rooms   []World
+		wantErr bool
+	}{
+		{name: "single world fallback", rooms: nil},
+		{name: "one unnamed room", rooms: []World{{}}},
+		{name: "named rooms", rooms: []World{{Name: "async"}, {Name: "sync"}}},
+		{name: "unnamed room among several", rooms: []World{{Name: "async"}, {}}, wantErr: true},
+		{name: "duplicate names", rooms: []World{{Name: "async"}, {Name: "async"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Multiworld{Rooms: tt.rooms}.CheckRooms()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
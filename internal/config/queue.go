@@ -0,0 +1,49 @@
+package config
+
+import "time"
+
+const (
+	defaultBatchWindow    = 2 * time.Second
+	defaultMaxBatch       = 500
+	defaultRetryAttempts  = 8
+	defaultMinBackoff     = 1 * time.Second
+	defaultMaxBackoff     = 2 * time.Minute
+	defaultDeadLetterFile = "./dead-letter.jsonl"
+	defaultDrainTimeout   = 30 * time.Second
+)
+
+type Queue struct {
+	// BatchWindow is how long the first waiting message is held so others can join it in one post
+	BatchWindow time.Duration `yaml:"batch_window"`
+	// MaxBatch caps how many messages are handed to the listeners at once
+	MaxBatch int `yaml:"max_batch"`
+	// Dir keeps the queue on disk so undelivered messages survive a restart, the queue is in memory only when empty
+	Dir string `yaml:"dir,omitempty"`
+	// DeadLetterFile is where batches that failed every retry are written. When it is empty or can't be written
+	// the batch stays queued and is retried after the max backoff.
+	DeadLetterFile string `yaml:"dead_letter_file"`
+	Retry          Retry  `yaml:"retry"`
+	// DrainTimeout is how long shutdown waits for queued messages to be sent
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+type Retry struct {
+	// Attempts is how many times a batch is sent to a listener before it is dead lettered
+	Attempts   int           `yaml:"attempts"`
+	MinBackoff time.Duration `yaml:"min_backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+func newDefaultQueue() Queue {
+	return Queue{
+		BatchWindow:    defaultBatchWindow,
+		MaxBatch:       defaultMaxBatch,
+		DeadLetterFile: defaultDeadLetterFile,
+		DrainTimeout:   defaultDrainTimeout,
+		Retry: Retry{
+			Attempts:   defaultRetryAttempts,
+			MinBackoff: defaultMinBackoff,
+			MaxBackoff: defaultMaxBackoff,
+		},
+	}
+}
@@ -0,0 +1,27 @@
+package config
+
+const defaultSlackBaseURL = "https://slack.com/api"
+
+// Slack posts announcements to Slack as well as Discord. It is off unless a webhook or token is set.
+type Slack struct {
+	// WebhookURL posts through an incoming webhook, which is tied to the channel it was made for
+	WebhookURL string `yaml:"webhook_url,omitempty"`
+	// Token and ChannelID post through chat.postMessage instead of a webhook
+	Token     string `yaml:"token,omitempty"`
+	ChannelID string `yaml:"channel_id,omitempty"`
+	// TokenFile is read in place of Token so the token can be kept out of the config
+	TokenFile string `yaml:"token_file,omitempty"`
+	// BaseURL is where the Web API lives, only worth changing to point at a stand in for testing
+	BaseURL string `yaml:"base_url,omitempty"`
+}
+
+func newDefaultSlack() Slack {
+	return Slack{
+		BaseURL: defaultSlackBaseURL,
+	}
+}
+
+// Enabled is whether there is enough configured to post anything
+func (s Slack) Enabled() bool {
+	return s.WebhookURL != "" || s.Token != ""
+}
@@ -0,0 +1,15 @@
+package config
+
+const defaultSummarySchedule = "@daily"
+
+type Summary struct {
+	// Schedule is when the digest is posted, either a five field cron expression in local time or one of
+	// @hourly, @daily, @weekly and @monthly. It is daily unless set, setting it to "" turns the digest off.
+	Schedule string `yaml:"schedule"`
+}
+
+func newDefaultSummary() Summary {
+	return Summary{
+		Schedule: defaultSummarySchedule,
+	}
+}
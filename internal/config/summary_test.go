@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSummarySchedule(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{name: "daily by default", yaml: "queue:\n  max_batch: 5\n", want: "@daily"},
+		{name: "own schedule", yaml: "summary:\n  schedule: \"0 20 * * 5\"\n", want: "0 20 * * 5"},
+		{name: "turned off", yaml: "summary:\n  schedule: \"\"\n", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewDefaultConfig()
+			err := yaml.Unmarshal([]byte(tt.yaml), &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Summary.Schedule != tt.want {
+				t.Errorf("got schedule %q, want %q", cfg.Summary.Schedule, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{
+	"time", "seq", "room", "type", "sender", "receiver", "player", "item", "location", "entrance",
+	"importance", "sender_game", "receiver_game", "found", "message", "text",
+}
+
+func (r Record) csvRow() []string {
+	return []string{
+		r.Time.Format(time.RFC3339),
+		strconv.FormatUint(r.Seq, 10),
+		r.Room,
+		r.Type,
+		r.Sender,
+		r.Receiver,
+		r.Player,
+		r.Item,
+		r.Location,
+		r.Entrance,
+		r.Importance,
+		r.SenderGame,
+		r.ReceiverGame,
+		strconv.FormatBool(r.Found),
+		r.Message,
+		r.Text,
+	}
+}
+
+// ToCSV converts event logs to a single CSV with a header row, the logs are read in the order given
+func ToCSV(w io.Writer, logs ...io.Reader) error {
+	out := csv.NewWriter(w)
+	err := out.Write(csvHeader)
+	if err != nil {
+		return fmt.Errorf("unable to write csv: %w", err)
+	}
+
+	for _, log := range logs {
+		scanner := bufio.NewScanner(log)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+
+			r := Record{}
+			err = json.Unmarshal(scanner.Bytes(), &r)
+			if err != nil {
+				return fmt.Errorf("unable to read event on line %d: %w", line, err)
+			}
+
+			err = out.Write(r.csvRow())
+			if err != nil {
+				return fmt.Errorf("unable to write csv: %w", err)
+			}
+		}
+		if scanner.Err() != nil {
+			return fmt.Errorf("unable to read event log: %w", scanner.Err())
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,243 @@
+package eventlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+const rotatedTimeFormat = "20060102-150405"
+
+// Record is one line of the event log
+type Record struct {
+	Time         time.Time `json:"time"`
+	Seq          uint64    `json:"seq"`
+	Room         string    `json:"room,omitempty"`
+	Type         string    `json:"type"`
+	Sender       string    `json:"sender,omitempty"`
+	Receiver     string    `json:"receiver,omitempty"`
+	Player       string    `json:"player,omitempty"`
+	Item         string    `json:"item,omitempty"`
+	Location     string    `json:"location,omitempty"`
+	Entrance     string    `json:"entrance,omitempty"`
+	Importance   string    `json:"importance,omitempty"`
+	SenderGame   string    `json:"sender_game,omitempty"`
+	ReceiverGame string    `json:"receiver_game,omitempty"`
+	Found        bool      `json:"found,omitempty"`
+	Message      string    `json:"message,omitempty"`
+	// Text is the event as the server rendered it, for the events it sent text for
+	Text string `json:"text,omitempty"`
+}
+
+// EventLog is a sink that appends every event to a JSON lines file, moving it aside once it gets too big
+type EventLog struct {
+	lock     sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// New builds the event log sink and subscribes it to the queue
+func New(cfg config.EventLog, subscriber queue.Subscriber) (*EventLog, error) {
+	err := os.MkdirAll(filepath.Dir(cfg.File), fs.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create event log directory: %w", err)
+	}
+
+	l := &EventLog{
+		path:     cfg.File,
+		maxSize:  cfg.MaxSizeMB << 20,
+		maxFiles: cfg.MaxFiles,
+	}
+
+	subscriber.RegisterMessageListener("eventlog", l.WriteMessages)
+	return l, nil
+}
+
+func newRecord(msg queue.BroadcastMessage) Record {
+	r := Record{
+		Time:         msg.Time,
+		Seq:          msg.Seq,
+		Room:         msg.Room,
+		Type:         string(msg.Type),
+		Sender:       msg.Sender,
+		Receiver:     msg.Receiver,
+		Player:       msg.Player,
+		Item:         msg.Item,
+		Location:     msg.Location,
+		Entrance:     msg.Entrance,
+		SenderGame:   msg.SenderGame,
+		ReceiverGame: msg.ReceiverGame,
+		Found:        msg.Found,
+		Message:      msg.Message,
+	}
+	if msg.Item != "" {
+		r.Importance = msg.Importance.Name()
+	}
+
+	sb := strings.Builder{}
+	for _, p := range msg.Parts {
+		sb.WriteString(p.Text)
+	}
+	r.Text = sb.String()
+
+	return r
+}
+
+// WriteMessages appends a batch to the log. Reports are left out, they are only ever made from the events.
+func (l *EventLog) WriteMessages(msgs []queue.BroadcastMessage) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	buf := make([]byte, 0)
+	for _, msg := range msgs {
+		if msg.Type == queue.EventDigest || msg.Type == queue.EventFinalReport {
+			continue
+		}
+
+		b, err := json.Marshal(newRecord(msg))
+		if err != nil {
+			return fmt.Errorf("unable to marshal event: %w", err)
+		}
+		buf = append(buf, b...)
+		buf = append(buf, '\n')
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+
+	err := l.open()
+	if err != nil {
+		return err
+	}
+
+	n, err := l.file.Write(buf)
+	if err != nil {
+		// the queue sends the whole batch again, so take back whatever part of it made it in
+		l.undo(n)
+		return fmt.Errorf("unable to write event log: %w", err)
+	}
+	l.size += int64(n)
+
+	// the events are in the log now, failing here would only get them written twice
+	if l.maxSize > 0 && l.size >= l.maxSize {
+		err = l.rotate()
+		if err != nil {
+			fmt.Printf("%s, carrying on with the current file\n", err)
+		}
+	}
+
+	return nil
+}
+
+// undo cuts a partly written batch back off the end of the log. It must be called with the lock held.
+func (l *EventLog) undo(written int) {
+	if written == 0 {
+		return
+	}
+
+	err := l.file.Truncate(l.size)
+	if err != nil {
+		fmt.Printf("unable to remove a partly written batch from the event log, it will be written twice: %s\n", err)
+	}
+
+	// opened again on the next write so the size is read back from the file
+	l.file.Close()
+	l.file = nil
+}
+
+// open must be called with the lock held
+func (l *EventLog) open() error {
+	if l.file != nil {
+		return nil
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fs.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to open event log: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("unable to read event log size: %w", err)
+	}
+
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// rotate moves the full log aside with the time and a count in its name and removes the oldest ones past the limit.
+// It must be called with the lock held.
+func (l *EventLog) rotate() error {
+	err := l.file.Close()
+	l.file = nil
+	if err != nil {
+		return fmt.Errorf("unable to close event log: %w", err)
+	}
+
+	ext := filepath.Ext(l.path)
+	base := strings.TrimSuffix(l.path, ext)
+	err = os.Rename(l.path, rotatedName(base, ext))
+	if err != nil {
+		return fmt.Errorf("unable to rotate event log: %w", err)
+	}
+
+	if l.maxFiles <= 0 {
+		return nil
+	}
+
+	rotated, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return fmt.Errorf("unable to list rotated event logs: %w", err)
+	}
+	// the time and count in the name sort oldest first
+	sort.Strings(rotated)
+	for len(rotated) > l.maxFiles {
+		err = os.Remove(rotated[0])
+		if err != nil {
+			return fmt.Errorf("unable to remove old event log: %w", err)
+		}
+		rotated = rotated[1:]
+	}
+
+	return nil
+}
+
+// rotatedName is a name for the full log that isn't taken yet, the count keeps rotations within the same second apart
+func rotatedName(base string, ext string) string {
+	stamp := time.Now().Format(rotatedTimeFormat)
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("%s-%s-%03d%s", base, stamp, n, ext)
+		_, err := os.Stat(name)
+		if err != nil {
+			// anything but the name not existing is left for the rename to report
+			return name
+		}
+	}
+}
+
+// Close releases the log file, it should only be called once the queue has stopped
+func (l *EventLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
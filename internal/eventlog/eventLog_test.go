@@ -0,0 +1,98 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+type noSubscriber struct{}
+
+func (noSubscriber) RegisterMessageListener(string, queue.MessageListener) {}
+
+func readSeqs(t *testing.T, path string) []uint64 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var seqs []uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		r := Record{}
+		err = json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, r.Seq)
+	}
+
+	return seqs
+}
+
+func TestRotationsInTheSameSecondKeepEveryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	l, err := New(config.EventLog{File: path, MaxFiles: 10}, noSubscriber{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// every batch fills the log
+	l.maxSize = 1
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		err = l.WriteMessages([]queue.BroadcastMessage{{Seq: seq, Type: queue.EventItemSend}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rotated, err := filepath.Glob(filepath.Join(filepath.Dir(path), "events-*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 3 {
+		t.Fatalf("got %d rotated logs, want 3: %v", len(rotated), rotated)
+	}
+
+	// oldest first by name, so the events come back in order
+	for i, name := range rotated {
+		seqs := readSeqs(t, name)
+		if len(seqs) != 1 || seqs[0] != uint64(i+1) {
+			t.Errorf("%s holds %v, want [%d]", filepath.Base(name), seqs, i+1)
+		}
+	}
+}
+
+func TestFailedRotationKeepsTheBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	l, err := New(config.EventLog{File: path, MaxFiles: 10}, noSubscriber{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	err = l.WriteMessages([]queue.BroadcastMessage{{Seq: 1, Type: queue.EventItemSend}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with the log gone from under it the write still works but moving it aside can't
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.maxSize = 1
+
+	err = l.WriteMessages([]queue.BroadcastMessage{{Seq: 2, Type: queue.EventItemSend}})
+	if err != nil {
+		t.Fatalf("write returned %q once the events were written, the queue would write them again", err)
+	}
+}
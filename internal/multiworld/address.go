@@ -0,0 +1,62 @@
+package multiworld
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const (
+	schemeSecure   = "wss"
+	schemeInsecure = "ws"
+
+	defaultPort = "38281"
+)
+
+// resolveAddresses works out which websocket URLs to try for a room, in order of preference.
+// The server may be a bare host or a full URL such as ws://localhost:38281. When no scheme is
+// given either way wss is tried before falling back to ws, the same as the official client.
+func resolveAddresses(server string, port string, scheme string) ([]url.URL, error) {
+	host := server
+	if strings.Contains(server, "://") {
+		u, err := url.Parse(server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server address %s: %w", server, err)
+		}
+
+		if scheme == "" {
+			scheme = u.Scheme
+		}
+		host = u.Host
+	}
+
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		if port == "" {
+			port = defaultPort
+		}
+		host = net.JoinHostPort(host, port)
+	}
+
+	var schemes []string
+	switch strings.ToLower(scheme) {
+	case "":
+		schemes = []string{schemeSecure, schemeInsecure}
+	case schemeSecure:
+		schemes = []string{schemeSecure}
+	case schemeInsecure:
+		schemes = []string{schemeInsecure}
+	default:
+		return nil, fmt.Errorf("unsupported scheme %s, must be %s or %s", scheme, schemeSecure, schemeInsecure)
+	}
+
+	addresses := make([]url.URL, 0, len(schemes))
+	for _, s := range schemes {
+		addresses = append(addresses, url.URL{
+			Scheme: s,
+			Host:   host,
+		})
+	}
+
+	return addresses, nil
+}
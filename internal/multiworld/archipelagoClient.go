@@ -3,12 +3,18 @@ package multiworld
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+	"github.com/civilrights3/go-derek-go/internal/room"
 	"github.com/coder/websocket"
 	"github.com/coder/websocket/wsjson"
 )
@@ -20,104 +26,205 @@ type MultiworldClient interface {
 }
 
 type ArchipelagoClient struct {
+	// lock guards the socket and message channel, which are swapped out by the read loop on every
+	// reconnect, along with the slot the bot is connected as and the address that worked
+	lock          sync.Mutex
 	socket        *websocket.Conn
 	clientID      string
 	clientVersion *semver.Version
+	room          string
 	connection    connection
+	team          int
+	slot          int
 	maxRetry      time.Duration
 	minRetry      time.Duration
 	dataCache     *dataCache
-	messageChan   chan any
+	// state is what the room looks like right now, for answering questions about it
+	state *roomState
+	// milestones are the percentages of checks that get announced
+	milestones []int
+	// progressDir is where the checks and milestones of each room are kept between restarts, progressFile is
+	// the current room's file once its seed is known
+	progressDir  string
+	progressFile string
+	messageChan  chan any
+	publisher    queue.Publisher
+	// stopping is set once shutdown starts, after that nothing new is read and the socket isn't reconnected
+	stopping atomic.Bool
+	// stopped is closed along with stopping being set, so waiting for a reconnect ends straight away
+	stopped  chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
 }
 
 type connection struct {
 	name     string
 	password string
-	address  url.URL
+	// addresses are tried in order until one connects, after which only that one is kept
+	addresses []url.URL
 }
 
-func NewArchipelagoClient(cfg config.Multiworld) (*ArchipelagoClient, error) {
-	cache := newDataCache(cfg.Cache.Filepath)
-	err := cache.loadCacheFromFS()
+// NewArchipelagoClient builds the client for a single room. Every room shares the same game cache.
+func NewArchipelagoClient(cfg config.Multiworld, room config.World, games *GameCache, publisher queue.Publisher) (*ArchipelagoClient, error) {
+	addresses, err := resolveAddresses(room.Server, room.Port, room.Scheme)
 	if err != nil {
-		// Yes this will crash the start of the application. If not it'll just have a crash loop later when saving caches
-		return nil, fmt.Errorf("error loading cache from FS: %w", err)
+		return nil, err
 	}
 
 	return &ArchipelagoClient{
 		clientID:      cfg.ClientID,
 		clientVersion: semver.MustParse(cfg.ClientVersion),
-		maxRetry:      time.Duration(cfg.MaxConnectionRetry) * time.Second,
-		minRetry:      1 * time.Second,
-		dataCache:     cache,
+		room:          room.Name,
+		connection: connection{
+			name:      room.Slot,
+			password:  room.Password,
+			addresses: addresses,
+		},
+		maxRetry:    time.Duration(cfg.MaxConnectionRetry) * time.Second,
+		minRetry:    1 * time.Second,
+		dataCache:   newDataCache(games),
+		state:       newRoomState(),
+		milestones:  cfg.ProgressMilestones,
+		progressDir: filepath.Join(cfg.Cache.Filepath, progressDir),
+		publisher:   publisher,
+		stopped:     make(chan struct{}),
+		done:        make(chan struct{}),
 	}, nil
 }
 
-func (a *ArchipelagoClient) Start(ctx context.Context, address string, port string, slot string) {
-	a.connection = connection{
-		name:     slot,
-		password: "", // TODO do i bother supporting passwords?
-		address: url.URL{
-			Scheme: "wss", // TODO make this smart enough to determine based on URL
-			Host:   fmt.Sprintf("%s:%s", address, port),
-		},
+// Start connects to the room and keeps reconnecting until the context is done or the client is closed
+func (a *ArchipelagoClient) Start(ctx context.Context) {
+	go a.startReadLoop(ctx)
+}
+
+// Room is the name the room's messages are tagged with
+func (a *ArchipelagoClient) Room() string {
+	return a.room
+}
+
+// Status is what is known about the room right now
+func (a *ArchipelagoClient) Status() room.Status {
+	address := ""
+	a.lock.Lock()
+	if len(a.connection.addresses) == 1 {
+		address = a.connection.addresses[0].Host
 	}
+	a.lock.Unlock()
 
-	go a.startReadLoop(ctx)
+	return a.state.status(a.room, address, a.connection.name)
+}
 
-	return
+// StopReading drops everything the server sends from now on, so no new announcements are queued while shutting down
+func (a *ArchipelagoClient) StopReading() {
+	a.stop()
+}
+
+func (a *ArchipelagoClient) stop() {
+	a.stopOnce.Do(func() {
+		a.stopping.Store(true)
+		close(a.stopped)
+	})
+}
+
+// Close says goodbye to the server with a normal closure and waits for the read loop to finish
+func (a *ArchipelagoClient) Close(ctx context.Context) error {
+	a.stop()
+
+	a.lock.Lock()
+	sock := a.socket
+	a.lock.Unlock()
+
+	if sock != nil {
+		err := sock.Close(websocket.StatusNormalClosure, "Derek signing off")
+		if err != nil && websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+			return fmt.Errorf("unable to close websocket: %w", err)
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-a.done:
+		a.saveProgress()
+		return nil
+	}
 }
 
 func (a *ArchipelagoClient) startReadLoop(ctx context.Context) {
+	defer close(a.done)
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			a.messageChan = make(chan any, 10)
-			if a.socket == nil {
+			if a.stopping.Load() {
+				return
+			}
+
+			if a.currentSocket() == nil {
 				a.connect(ctx)
+				if a.currentSocket() == nil {
+					// connect only gives up when shutting down
+					continue
+				}
 			}
 
-			go a.writeLoop(ctx)
-			a.readLoop(ctx)
+			messages := make(chan any, 10)
+			a.lock.Lock()
+			a.messageChan = messages
+			a.lock.Unlock()
+
+			go a.writeLoop(ctx, a.currentSocket(), messages)
+			err := a.readLoop(ctx)
 
 			a.disconnect(ctx)
+			a.lock.Lock()
 			close(a.messageChan)
 			a.messageChan = nil
+			a.lock.Unlock()
+
+			if errors.Is(err, ErrPasswordRequired) || errors.Is(err, ErrInvalidPassword) {
+				// reconnecting won't fix the config, so give up on this room
+				fmt.Printf("stopping multiworld connection: %s\n", err)
+				return
+			}
 		}
 	}
 }
 
-func (a *ArchipelagoClient) readLoop(ctx context.Context) {
+func (a *ArchipelagoClient) readLoop(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
-			a.disconnect(ctx)
-			return
+			return ctx.Err()
 		default:
 			b, err := a.readSock(ctx)
 			if err != nil {
 				fmt.Printf("error reading socket: %s\n", err)
-				return
+				return err
 			}
 
+			// a packet that can't be handled is reported and skipped, only being refused by the room is worth
+			// a new connection
 			err = a.handleMessage(ctx, b)
+			if errors.Is(err, ErrConnectionRefused) || errors.Is(err, ErrPasswordRequired) || errors.Is(err, ErrInvalidPassword) {
+				fmt.Printf("unable to join room: %s\n", err)
+				return err
+			}
 			if err != nil {
 				fmt.Printf("unable to handle message: %s\n", err)
-				return
 			}
 		}
 	}
 }
 
-func (a *ArchipelagoClient) writeLoop(ctx context.Context) {
+func (a *ArchipelagoClient) writeLoop(ctx context.Context, socket *websocket.Conn, messages <-chan any) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case msg, open := <-a.messageChan:
-			if !open || a.socket == nil {
+		case msg, open := <-messages:
+			if !open || socket == nil {
 				return
 			}
 
@@ -129,7 +236,7 @@ func (a *ArchipelagoClient) writeLoop(ctx context.Context) {
 			}
 			fmt.Printf("%s\n", b)
 
-			err = wsjson.Write(ctx, a.socket, msgs)
+			err = wsjson.Write(ctx, socket, msgs)
 			if err != nil {
 				fmt.Printf("error occured when sending message to server: %s\n", err)
 				// TODO how do we retry sends? should we?
@@ -143,20 +250,32 @@ func (a *ArchipelagoClient) writeLoop(ctx context.Context) {
 func (a *ArchipelagoClient) connect(ctx context.Context) {
 	currentRetry := a.minRetry
 
+	// shutting down abandons a dial that is still waiting on the server
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-a.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	for {
 		timer := time.NewTimer(currentRetry)
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			return
 		case <-timer.C:
-			fmt.Println(a.connection.address.String())
-			c, _, err := websocket.Dial(ctx, a.connection.address.String(), &websocket.DialOptions{
-				CompressionMode: websocket.CompressionDisabled,
-			})
+
+			c, err := a.dial(ctx)
 			if err == nil {
 				c.SetReadLimit(-1)
 
+				a.lock.Lock()
 				a.socket = c
+				a.lock.Unlock()
 				return
 			}
 
@@ -170,9 +289,77 @@ func (a *ArchipelagoClient) connect(ctx context.Context) {
 	}
 }
 
+// dial tries each candidate address in turn. The first one that works is remembered so reconnects
+// don't go through the fallback again.
+func (a *ArchipelagoClient) dial(ctx context.Context) (*websocket.Conn, error) {
+	c, addr, err := dialAny(ctx, a.connection.addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	a.lock.Lock()
+	a.connection.addresses = []url.URL{addr}
+	a.lock.Unlock()
+	return c, nil
+}
+
+// dialAny connects to the first address that answers
+func dialAny(ctx context.Context, addresses []url.URL) (*websocket.Conn, url.URL, error) {
+	var err error
+	for _, addr := range addresses {
+		fmt.Println(addr.String())
+
+		var c *websocket.Conn
+		c, _, err = websocket.Dial(ctx, addr.String(), &websocket.DialOptions{
+			CompressionMode: websocket.CompressionDisabled,
+		})
+		if err == nil {
+			return c, addr, nil
+		}
+
+		fmt.Printf("Failed to connect to %s: %s\n", addr.String(), err)
+	}
+
+	return nil, url.URL{}, err
+}
+
+// send queues a packet for the write loop without blocking, it fails when the connection is down
+func (a *ArchipelagoClient) send(msg any) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.messageChan == nil || a.socket == nil {
+		return ErrNotConnected
+	}
+
+	select {
+	case a.messageChan <- msg:
+		return nil
+	default:
+		return fmt.Errorf("too many packets waiting to be sent")
+	}
+}
+
+func (a *ArchipelagoClient) isOwnSlot(team int, slot int) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.team == team && a.slot == slot
+}
+
+func (a *ArchipelagoClient) currentSocket() *websocket.Conn {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.socket
+}
+
 func (a *ArchipelagoClient) disconnect(ctx context.Context) {
+	a.lock.Lock()
 	sock := a.socket
 	a.socket = nil
+	a.lock.Unlock()
+	a.state.setConnected(false)
+	if sock == nil {
+		return
+	}
 
 	err := sock.CloseNow()
 	if err != nil && websocket.CloseStatus(err) != websocket.StatusNormalClosure {
@@ -187,43 +374,67 @@ func (a *ArchipelagoClient) readSock(ctx context.Context) (b []byte, err error)
 		}
 	}()
 
-	_, b, err = a.socket.Read(ctx)
+	_, b, err = a.currentSocket().Read(ctx)
 	return
 }
 
 func (a *ArchipelagoClient) handleMessage(ctx context.Context, msg []byte) error {
+	if a.stopping.Load() {
+		return nil
+	}
+
 	fmt.Printf("%s\n", msg)
 	msgs, err := a.parse(msg)
 	if err != nil {
 		return err
 	}
 
-	for _, m := range msgs {
+	// every packet in the frame gets dispatched, a failing packet must not drop the ones after it
+	var errs frameError
+	for i, m := range msgs {
 		fmt.Printf("received command %s\n", m.Type)
-		switch m.Type {
-		case CmdRoomInfo:
-			return a.handleRoomInfo(ctx, m.Payload)
-		case CmdDataPackage:
-			return a.handleDataPackage(ctx, m.Payload)
-		case CmdConnected:
-			return a.handleConnected(ctx, m.Payload)
-		case CmdConnectionRefused:
-			return a.handleConnectionRefused(ctx, m.Payload)
-		case CmdRoomUpdate:
-			return a.handleRoomUpdate(ctx, m.Payload)
-		case CmdPrintJSON:
-			return a.handlePrintJSON(ctx, m.Payload)
-		case CmdInvalidPacket:
-			return a.handleInvalidPacket(ctx, m.Payload)
-		default:
-			fmt.Printf("unknown command: %s\n", m.Type)
-			continue
+		err = a.dispatch(ctx, m)
+		if err != nil {
+			fmt.Printf("unable to handle command %s (%d of %d in frame): %s\n", m.Type, i+1, len(msgs), err)
+			errs = append(errs, packetError{Index: i, Type: m.Type, Err: err})
 		}
 	}
 
+	if len(errs) > 0 {
+		return errs
+	}
+
 	return nil
 }
 
+func (a *ArchipelagoClient) dispatch(ctx context.Context, m RawMsg) error {
+	switch m.Type {
+	case CmdRoomInfo:
+		return a.handleRoomInfo(ctx, m.Payload)
+	case CmdDataPackage:
+		return a.handleDataPackage(ctx, m.Payload)
+	case CmdConnected:
+		return a.handleConnected(ctx, m.Payload)
+	case CmdConnectionRefused:
+		return a.handleConnectionRefused(ctx, m.Payload)
+	case CmdRoomUpdate:
+		return a.handleRoomUpdate(ctx, m.Payload)
+	case CmdReceivedItems:
+		return a.handleReceivedItems(ctx, m.Payload)
+	case CmdRetrieved:
+		return a.handleRetrieved(ctx, m.Payload)
+	case CmdSetReply:
+		return a.handleSetReply(ctx, m.Payload)
+	case CmdPrintJSON:
+		return a.handlePrintJSON(ctx, m.Payload)
+	case CmdInvalidPacket:
+		return a.handleInvalidPacket(ctx, m.Payload)
+	default:
+		fmt.Printf("unknown command: %s\n", m.Type)
+		return nil
+	}
+}
+
 func (a *ArchipelagoClient) parse(msg []byte) ([]RawMsg, error) {
 	out := make([]map[string]interface{}, 0)
 	err := json.Unmarshal(msg, &out)
@@ -235,7 +446,10 @@ func (a *ArchipelagoClient) parse(msg []byte) ([]RawMsg, error) {
 	parsed := []RawMsg{}
 	for _, m := range out {
 		raw, _ := json.Marshal(m)
-		t := m["cmd"].(string)
+		t, ok := m["cmd"].(string)
+		if !ok {
+			return nil, fmt.Errorf("packet without a cmd: %s", raw)
+		}
 		msg := RawMsg{
 			Type:    ServerMessageType(t),
 			Payload: raw,
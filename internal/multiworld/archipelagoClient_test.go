@@ -0,0 +1,152 @@
+package multiworld
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
+	"github.com/coder/websocket"
+)
+
+type discardPublisher struct{}
+
+func (discardPublisher) EnqueueMessage(queue.BroadcastMessage) {}
+
+// keepPublisher keeps what is enqueued
+type keepPublisher struct {
+	lock sync.Mutex
+	msgs []queue.BroadcastMessage
+}
+
+func (p *keepPublisher) EnqueueMessage(msg queue.BroadcastMessage) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.msgs = append(p.msgs, msg)
+}
+
+// serveFrames is a room that sends the frames to whoever connects and then hangs up
+func serveFrames(t *testing.T, frames ...string) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("accept: %s", err)
+			return
+		}
+		defer c.CloseNow()
+
+		for _, f := range frames {
+			err = c.Write(r.Context(), websocket.MessageText, []byte(f))
+			if err != nil {
+				return
+			}
+		}
+		c.Close(websocket.StatusNormalClosure, "bye")
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws://" + srv.Listener.Addr().String()
+}
+
+// readFrames runs the read loop over a connection to the room until the room hangs up
+func readFrames(t *testing.T, address string, publisher queue.Publisher) error {
+	t.Helper()
+	games, err := NewGameCache(config.Cache{Filepath: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewArchipelagoClient(config.NewDefaultConfig().Multiworld, config.World{Name: "test", Server: address}, games, publisher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a.socket, _, err = websocket.Dial(ctx, address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.readLoop(ctx)
+}
+
+func TestReadLoopCarriesOnAfterAFailingPacket(t *testing.T) {
+	address := serveFrames(t,
+		`[{"cmd":"PrintJSON","type":"ServerChat","message":"one"},`+
+			`{"cmd":"InvalidPacket","type":"cmd","original_cmd":"Sya","text":"unknown command"},`+
+			`{"cmd":"PrintJSON","type":"ServerChat","message":"two"}]`,
+		`[{"cmd":"PrintJSON","type":"ServerChat","message":"three"}]`,
+	)
+	publisher := &keepPublisher{}
+
+	err := readFrames(t, address, publisher)
+	if websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+		t.Errorf("read loop stopped with %v, want the room hanging up", err)
+	}
+
+	var got []string
+	for _, m := range publisher.msgs {
+		got = append(got, m.Message)
+	}
+	if strings.Join(got, ",") != "one,two,three" {
+		t.Errorf("got %q, want every packet around the failing one", got)
+	}
+}
+
+func TestReadLoopStopsWhenRefused(t *testing.T) {
+	address := serveFrames(t,
+		`[{"cmd":"ConnectionRefused","errors":["InvalidPassword"]}]`,
+		`[{"cmd":"PrintJSON","type":"ServerChat","message":"one"}]`,
+	)
+
+	err := readFrames(t, address, discardPublisher{})
+	if !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("read loop stopped with %v, want the refusal", err)
+	}
+}
+
+func TestCloseDuringConnectBackoff(t *testing.T) {
+	// a port that was just free, so every dial is refused and the client sits in its backoff
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := config.NewDefaultConfig().Multiworld
+	cfg.MaxConnectionRetry = 3600
+	games, err := NewGameCache(config.Cache{Filepath: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewArchipelagoClient(cfg, config.World{Name: "test", Server: "ws://" + addr}, games, discardPublisher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// long enough that the test would time out if Close waited it out
+	a.minRetry = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.Start(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer closeCancel()
+	start := time.Now()
+	err = a.Close(closeCtx)
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("close waited %s for the backoff", waited)
+	}
+}
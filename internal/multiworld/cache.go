@@ -8,42 +8,57 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+	"github.com/civilrights3/go-derek-go/internal/queue"
 )
 
-type dataCache struct {
-	fileRoot     string
-	playersByID  map[int]Player
-	playerToGame map[int]string
-	games        map[string]saneGame
-	checksums    map[string]string
+// GameCache holds the datapackage of every game seen so far. It is shared by every room so a game
+// is only ever downloaded once.
+type GameCache struct {
+	lock      sync.RWMutex
+	fileRoot  string
+	games     map[string]saneGame
+	checksums map[string]string
 }
 
-// TODO consider a lock between read and write?
+// NewGameCache loads the datapackages already saved to disk
+func NewGameCache(cfg config.Cache) (*GameCache, error) {
+	c := &GameCache{
+		games:     make(map[string]saneGame),
+		checksums: make(map[string]string),
+		fileRoot:  cfg.Filepath,
+	}
 
-func newDataCache(fileRoot string) *dataCache {
-	return &dataCache{
-		playersByID:  make(map[int]Player),
-		playerToGame: make(map[int]string),
-		games:        make(map[string]saneGame),
-		checksums:    make(map[string]string),
-		fileRoot:     fileRoot,
+	err := c.loadCacheFromFS()
+	if err != nil {
+		// Yes this will crash the start of the application. If not it'll just have a crash loop later when saving caches
+		return nil, fmt.Errorf("error loading cache from FS: %w", err)
 	}
+
+	return c, nil
 }
 
-func (c *dataCache) loadCacheFromFS() error {
+func (c *GameCache) loadCacheFromFS() error {
 	gameList, err := os.ReadDir(c.fileRoot)
 	if err != nil {
 		if os.IsNotExist(err) {
-			err = os.MkdirAll(c.fileRoot, fs.ModeDir)
+			err = os.MkdirAll(c.fileRoot, fs.ModePerm)
 			if err != nil {
 				return fmt.Errorf("cannot create cache directory: %w", err)
 			}
+			return nil
 		}
 
 		return fmt.Errorf("unable to read cache dir: %w", err)
 	}
 
 	for _, f := range gameList {
+		if f.IsDir() {
+			continue
+		}
+
 		gameName := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
 		contents, err := os.ReadFile(filepath.Join(c.fileRoot, f.Name()))
 		if err != nil {
@@ -63,22 +78,10 @@ func (c *dataCache) loadCacheFromFS() error {
 	return nil
 }
 
-func (c *dataCache) setPlayers(players []Player, info map[string]SlotInfo) {
-	c.playersByID = make(map[int]Player)
-	for _, p := range players {
-		c.playersByID[p.Slot] = p
-	}
-
-	for _, i := range info {
-		for _, p := range players {
-			if i.Name == p.Name {
-				c.playerToGame[p.Slot] = i.Game
-			}
-		}
-	}
-}
+func (c *GameCache) getListOfUpdates(games map[string]string) []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 
-func (c *dataCache) getListOfUpdates(games map[string]string) []string {
 	var updates []string
 	for name, check := range games {
 		cs, ok := c.checksums[name]
@@ -90,7 +93,10 @@ func (c *dataCache) getListOfUpdates(games map[string]string) []string {
 	return updates
 }
 
-func (c *dataCache) updateCache(updates *DataPackageMessage) error {
+func (c *GameCache) updateCache(updates *DataPackageMessage) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	for name, g := range updates.Data.Games {
 		c.games[name] = saneitizeGame(g)
 		c.checksums[name] = g.Checksum
@@ -99,7 +105,8 @@ func (c *dataCache) updateCache(updates *DataPackageMessage) error {
 	return c.saveCacheToFS()
 }
 
-func (c *dataCache) saveCacheToFS() error {
+// saveCacheToFS must be called with the lock held
+func (c *GameCache) saveCacheToFS() error {
 	for name, g := range c.games {
 		b, err := json.Marshal(g)
 		if err != nil {
@@ -115,12 +122,62 @@ func (c *dataCache) saveCacheToFS() error {
 	return nil
 }
 
+func (c *GameCache) game(name string) saneGame {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.games[name]
+}
+
+// dataCache is what a single room knows about its players, on top of the shared game data
+type dataCache struct {
+	games *GameCache
+	// lock guards the players, which change on every reconnect while chat commands are reading them
+	lock         sync.RWMutex
+	playersByID  map[int]Player
+	playerToGame map[int]string
+}
+
+func newDataCache(games *GameCache) *dataCache {
+	return &dataCache{
+		games:        games,
+		playersByID:  make(map[int]Player),
+		playerToGame: make(map[int]string),
+	}
+}
+
+func (c *dataCache) setPlayers(players []Player, info map[string]SlotInfo) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.playersByID = make(map[int]Player)
+	for _, p := range players {
+		c.playersByID[p.Slot] = p
+	}
+
+	for _, i := range info {
+		for _, p := range players {
+			if i.Name == p.Name {
+				c.playerToGame[p.Slot] = i.Game
+			}
+		}
+	}
+}
+
+func (c *dataCache) getListOfUpdates(games map[string]string) []string {
+	return c.games.getListOfUpdates(games)
+}
+
+func (c *dataCache) updateCache(updates *DataPackageMessage) error {
+	return c.games.updateCache(updates)
+}
+
 func (c *dataCache) GetPlayerNameForSlotStr(slot string) string {
 	slotNum, _ := strconv.Atoi(slot)
 	return c.GetPlayerNameForSlot(slotNum)
 }
 
 func (c *dataCache) GetPlayerNameForSlot(slot int) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	player, ok := c.playersByID[slot]
 	if !ok {
 		return fmt.Sprintf("%d", slot)
@@ -129,18 +186,60 @@ func (c *dataCache) GetPlayerNameForSlot(slot int) string {
 	return player.Name
 }
 
+func (c *dataCache) GetGameForSlot(slot int) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.playerToGame[slot]
+}
+
+// locationCount is the number of locations in a game, every one of them whether a slot has it turned on or not
+func (c *dataCache) locationCount(game string) int {
+	return len(c.games.game(game).LocationIDToName)
+}
+
 func (c *dataCache) GetLocationNameForIDForPlayer(locationID int, playerID int) string {
-	gameName := c.playerToGame[playerID]
-	gameDetails := c.games[gameName]
+	gameName := c.GetGameForSlot(playerID)
+	gameDetails := c.games.game(gameName)
 	return gameDetails.LocationIDToName[locationID]
 }
 
 func (c *dataCache) GetItemNameForIDForPlayer(itemID int, playerID int) string {
-	gameName := c.playerToGame[playerID]
-	gameDetails := c.games[gameName]
+	gameName := c.GetGameForSlot(playerID)
+	gameDetails := c.games.game(gameName)
 	return gameDetails.ItemNameToId[itemID]
 }
 
+// renderJSONData resolves the ids in a PrintJSON data list to names, the same way the official text client does
+func (c *dataCache) renderJSONData(elements []JSONDataElement) []queue.TextPart {
+	parts := make([]queue.TextPart, 0, len(elements))
+	for _, e := range elements {
+		switch e.Type {
+		case DataTypePlayerID:
+			parts = append(parts, queue.TextPart{Type: queue.TextPlayer, Text: c.GetPlayerNameForSlotStr(e.Text)})
+		case DataTypePlayerName:
+			parts = append(parts, queue.TextPart{Type: queue.TextPlayer, Text: e.Text})
+		case DataTypeItemID:
+			id, _ := strconv.Atoi(e.Text)
+			parts = append(parts, queue.TextPart{Type: queue.TextItem, Text: c.GetItemNameForIDForPlayer(id, e.Player), Importance: e.Flags})
+		case DataTypeItemName:
+			parts = append(parts, queue.TextPart{Type: queue.TextItem, Text: e.Text, Importance: e.Flags})
+		case DataTypeLocationID:
+			id, _ := strconv.Atoi(e.Text)
+			parts = append(parts, queue.TextPart{Type: queue.TextLocation, Text: c.GetLocationNameForIDForPlayer(id, e.Player)})
+		case DataTypeLocationName:
+			parts = append(parts, queue.TextPart{Type: queue.TextLocation, Text: e.Text})
+		case DataTypeEntranceName:
+			parts = append(parts, queue.TextPart{Type: queue.TextEntrance, Text: e.Text})
+		case DataTypeColor:
+			parts = append(parts, queue.TextPart{Type: queue.TextColor, Text: e.Text, Color: e.Color})
+		default:
+			parts = append(parts, queue.TextPart{Type: queue.TextPlain, Text: e.Text})
+		}
+	}
+
+	return parts
+}
+
 type saneGame struct {
 	LocationIDToName map[int]string `json:"location_id_to_name"`
 	ItemNameToId     map[int]string `json:"item_name_to_id"`
@@ -0,0 +1,57 @@
+package multiworld
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrPasswordRequired is returned when the room needs a password and none is configured
+	ErrPasswordRequired = errors.New("room requires a password but none is configured")
+	// ErrInvalidPassword is returned when the server refuses the configured room password
+	ErrInvalidPassword = errors.New("room password was rejected")
+	// ErrConnectionRefused is returned when the server won't let the bot into the room, it is retried with a new
+	// connection
+	ErrConnectionRefused = errors.New("connection refused")
+	// ErrNotConnected is returned when sending while there is no connection to the server
+	ErrNotConnected = errors.New("not connected to the room")
+)
+
+// packetError is the error of a single packet within a frame
+type packetError struct {
+	Index int
+	Type  ServerMessageType
+	Err   error
+}
+
+func (p packetError) Error() string {
+	return fmt.Sprintf("packet %d (%s): %s", p.Index, p.Type, p.Err)
+}
+
+func (p packetError) Unwrap() error {
+	return p.Err
+}
+
+// frameError collects the errors of every packet in a frame that failed to be handled
+type frameError []packetError
+
+func (f frameError) Error() string {
+	msgs := make([]string, 0, len(f))
+	for _, e := range f {
+		msgs = append(msgs, e.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Is matches target against the error of any packet in the frame
+func (f frameError) Is(target error) bool {
+	for _, e := range f {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+
+	return false
+}
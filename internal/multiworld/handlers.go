@@ -16,19 +16,32 @@ func (a *ArchipelagoClient) handleRoomInfo(_ context.Context, b []byte) error {
 	}
 	fmt.Printf("%+v\n", out)
 
+	if out.PasswordReqd && a.connection.password == "" {
+		return ErrPasswordRequired
+	}
+
+	a.loadProgress(out.SeedName)
+
 	// determine data package updates needed
 	updates := a.dataCache.getListOfUpdates(out.DataPackageChecksum)
-	a.sendGetDataPackage(updates)
+	err = a.sendGetDataPackage(updates)
+	if err != nil {
+		return err
+	}
 
 	// send slot info
-	a.sendConnect()
-	return nil
+	return a.sendConnect()
+}
+
+func (a *ArchipelagoClient) sendConnect() error {
+	return a.send(a.connectMessage(a.connection.name))
 }
 
-func (a *ArchipelagoClient) sendConnect() {
+// connectMessage is the Connect packet for a slot, the bot only ever connects as a text client
+func (a *ArchipelagoClient) connectMessage(slot string) ConnectMessage {
 	body := ConnectMessage{
 		Cmd:  CmdConnect.String(),
-		Name: a.connection.name,
+		Name: slot,
 		Version: Version{
 			Major: a.clientVersion.Major(),
 			Minor: a.clientVersion.Minor(),
@@ -40,13 +53,17 @@ func (a *ArchipelagoClient) sendConnect() {
 		Tags:          []string{"TextOnly", "IgnoreGame", "AP", "Derek"},
 	}
 
-	a.messageChan <- body
-	return
+	if a.connection.password != "" {
+		password := a.connection.password
+		body.Password = &password
+	}
+
+	return body
 }
 
-func (a *ArchipelagoClient) sendGetDataPackage(games []string) {
+func (a *ArchipelagoClient) sendGetDataPackage(games []string) error {
 	if len(games) == 0 {
-		return
+		return nil
 	}
 
 	body := GetDataPackageMessage{
@@ -54,8 +71,15 @@ func (a *ArchipelagoClient) sendGetDataPackage(games []string) {
 		Games: games,
 	}
 
-	a.messageChan <- body
-	return
+	return a.send(body)
+}
+
+// Say sends a chat message to the room as the bot's slot
+func (a *ArchipelagoClient) Say(text string) error {
+	return a.send(SayMessage{
+		Cmd:  CmdSay.String(),
+		Text: text,
+	})
 }
 
 func (a *ArchipelagoClient) handleDataPackage(_ context.Context, b []byte) error {
@@ -83,14 +107,57 @@ func (a *ArchipelagoClient) handleConnected(_ context.Context, b []byte) error {
 	}
 
 	a.dataCache.setPlayers(out.Players, out.SlotInfo)
-	return nil
+	a.state.setPlayers(out.Team, out.Slot, out.HintPoints, out.Players, out.SlotInfo)
+	a.state.setLocations(out.CheckedLocations, out.MissingLocations, a.locationTotals(out.SlotInfo), a.milestones)
+
+	a.lock.Lock()
+	a.team = out.Team
+	a.slot = out.Slot
+	a.lock.Unlock()
+
+	return a.sendWatch()
 }
 
 func (a *ArchipelagoClient) handleConnectionRefused(_ context.Context, b []byte) error {
-	return fmt.Errorf("connection refused: %s", b)
+	out := &ConnectionRefusedMessage{}
+	err := json.Unmarshal(b, &out)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	for _, e := range out.Errors {
+		if e == RefusedInvalidPassword {
+			return fmt.Errorf("connection refused: %w", ErrInvalidPassword)
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrConnectionRefused, b)
 }
 
-func (a *ArchipelagoClient) handleRoomUpdate(ctx context.Context, b []byte) error {
+func (a *ArchipelagoClient) handleRoomUpdate(_ context.Context, b []byte) error {
+	out := &RoomUpdateMessage{}
+	err := json.Unmarshal(b, &out)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if a.state.roomUpdate(out) {
+		a.checkProgress(a.state.ownSlot())
+	}
+	return nil
+}
+
+func (a *ArchipelagoClient) handleReceivedItems(_ context.Context, b []byte) error {
+	out := &ReceivedItemsMessage{}
+	err := json.Unmarshal(b, &out)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	a.state.receivedItems(out.Index, len(out.Items))
 	return nil
 }
 
@@ -102,21 +169,105 @@ func (a *ArchipelagoClient) handlePrintJSON(ctx context.Context, b []byte) error
 		return err
 	}
 
-	if out.Type == JSONDataTypeItemSend {
-		transformed := queue.BroadcastMessage{
-			Sender:     a.dataCache.GetPlayerNameForSlot(out.Item.Player),
-			Receiver:   a.dataCache.GetPlayerNameForSlot(out.Receiving),
-			Item:       a.dataCache.GetItemNameForIDForPlayer(out.Item.Item, out.Receiving),
-			Location:   a.dataCache.GetLocationNameForIDForPlayer(out.Item.Location, out.Item.Player),
-			Importance: out.Item.Flags,
+	if out.Type == JSONDataTypeChat && a.isOwnSlot(out.Team, out.Slot) {
+		// our own chat is what was relayed in from Discord, sending it back would loop
+		return nil
+	}
+	if out.Type == JSONDataTypeGoal && !a.state.markGoal(out.Slot) {
+		// already announced from the slot's client status
+		return nil
+	}
+
+	var transformed queue.BroadcastMessage
+	switch out.Type {
+	case JSONDataTypeItemSend, JSONDataTypeItemCheat, JSONDataTypeHint:
+		if out.Type == JSONDataTypeItemSend && a.state.itemSent(out.Item.Player, out.Item.Location, out.Receiving, out.Item.Flags) {
+			a.checkProgress(out.Item.Player)
+		}
+		if out.Type == JSONDataTypeItemCheat {
+			a.state.itemCheat(out.Receiving, out.Item.Flags)
+		}
+		if out.Type == JSONDataTypeHint && !a.state.recordHint(printedHint(out)) {
+			// already announced from data storage
+			return nil
+		}
+		transformed = a.itemEvent(out)
+	case JSONDataTypeJoin, JSONDataTypePart, JSONDataTypeChat, JSONDataTypeGoal, JSONDataTypeRelease, JSONDataTypeCollect, JSONDataTypeTagsChanged:
+		transformed = queue.BroadcastMessage{
+			Type:       queue.EventType(out.Type),
+			Player:     a.dataCache.GetPlayerNameForSlot(out.Slot),
+			SenderGame: a.dataCache.GetGameForSlot(out.Slot),
+			Message:    out.Message,
+			Tags:       out.Tags,
 		}
+	case JSONDataTypeServerChat:
+		transformed = queue.BroadcastMessage{
+			Type:    queue.EventServerChat,
+			Message: out.Message,
+		}
+	case JSONDataTypeCountdown:
+		transformed = queue.BroadcastMessage{
+			Type:      queue.EventCountdown,
+			Countdown: out.Countdown,
+		}
+	default:
+		// anything not modelled is shown the way the server rendered it
+		transformed = queue.BroadcastMessage{
+			Type: queue.EventText,
+		}
+	}
 
-		queue.Queue.EnqueueMessage(transformed)
+	if transformed.Type == queue.EventGoal {
+		transformed.Count, transformed.Total = a.state.goalProgress()
+	}
+
+	transformed.Room = a.room
+	transformed.Parts = a.dataCache.renderJSONData(out.Data)
+	if transformed.Type == queue.EventText && len(transformed.Parts) == 0 {
+		return nil
+	}
+
+	a.publisher.EnqueueMessage(transformed)
+	if transformed.Type == queue.EventGoal {
+		a.checkFinished()
 	}
-	// ignore other message types
 	return nil
 }
 
-func (a *ArchipelagoClient) handleInvalidPacket(ctx context.Context, b []byte) error {
-	panic(fmt.Sprintf("%s\n", b))
+// printedHint is the data storage form of a Hint PrintJSON, so both can be checked against the hint table
+func printedHint(out *PrintJSONMessage) Hint {
+	return Hint{
+		ReceivingPlayer: out.Receiving,
+		FindingPlayer:   out.Item.Player,
+		Location:        out.Item.Location,
+		Item:            out.Item.Item,
+		Found:           out.Found,
+		ItemFlags:       out.Item.Flags,
+	}
+}
+
+// itemEvent builds the message for the PrintJSON types that carry a NetworkItem
+func (a *ArchipelagoClient) itemEvent(out *PrintJSONMessage) queue.BroadcastMessage {
+	return queue.BroadcastMessage{
+		Type:         queue.EventType(out.Type),
+		Sender:       a.dataCache.GetPlayerNameForSlot(out.Item.Player),
+		Receiver:     a.dataCache.GetPlayerNameForSlot(out.Receiving),
+		Item:         a.dataCache.GetItemNameForIDForPlayer(out.Item.Item, out.Receiving),
+		Location:     a.dataCache.GetLocationNameForIDForPlayer(out.Item.Location, out.Item.Player),
+		Importance:   out.Item.Flags,
+		SenderGame:   a.dataCache.GetGameForSlot(out.Item.Player),
+		ReceiverGame: a.dataCache.GetGameForSlot(out.Receiving),
+		Found:        out.Found,
+	}
+}
+
+func (a *ArchipelagoClient) handleInvalidPacket(_ context.Context, b []byte) error {
+	out := &InvalidPacketMessage{}
+	err := json.Unmarshal(b, &out)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return fmt.Errorf("server rejected our %s packet (%s): %s", out.OriginalCmd, out.Type, out.Text)
 }
@@ -0,0 +1,203 @@
+package multiworld
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/queue"
+	"github.com/civilrights3/go-derek-go/internal/room"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+const (
+	// hintTimeout is how long a hint request can take from connecting to the first answer
+	hintTimeout = 15 * time.Second
+	// hintQuiet is how long to keep listening after an answer, the server sends one packet per matching hint
+	hintQuiet = 2 * time.Second
+)
+
+// hintSession is a short lived connection as a player's slot, the server only takes !hint from the slot it's for
+type hintSession struct {
+	client *ArchipelagoClient
+	socket *websocket.Conn
+	slot   string
+	item   string
+	// slotID is the slot number the server connected us as
+	slotID  int
+	answers []queue.BroadcastMessage
+}
+
+// RequestHint asks the server for a hint on behalf of a slot, by connecting as that slot just long enough to
+// say !hint. It returns whatever the server answered, which is either the hints or why there aren't any.
+func (a *ArchipelagoClient) RequestHint(ctx context.Context, slot string, item string) ([]queue.BroadcastMessage, error) {
+	if a.stopping.Load() {
+		return nil, ErrNotConnected
+	}
+
+	a.lock.Lock()
+	addresses := make([]url.URL, len(a.connection.addresses))
+	copy(addresses, a.connection.addresses)
+	a.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, hintTimeout)
+	defer cancel()
+
+	socket, _, err := dialAny(ctx, addresses)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect for hint: %w", err)
+	}
+	socket.SetReadLimit(-1)
+	defer socket.CloseNow()
+
+	h := &hintSession{
+		client: a,
+		socket: socket,
+		slot:   slot,
+		item:   item,
+		slotID: -1,
+	}
+	err = h.run(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = socket.Close(websocket.StatusNormalClosure, "hint delivered")
+	if err != nil && websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+		fmt.Printf("unable to close hint connection: %s\n", err)
+	}
+
+	return h.answers, nil
+}
+
+// run reads from the server until the answers stop coming in. The reader is left blocked on the socket,
+// closing it or cancelling the context ends it.
+func (h *hintSession) run(ctx context.Context) error {
+	frames := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			_, b, err := h.socket.Read(ctx)
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			select {
+			case frames <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var quiet <-chan time.Time
+	for {
+		select {
+		case <-quiet:
+			// gone quiet, everything has been answered
+			return nil
+		case <-ctx.Done():
+			if len(h.answers) > 0 {
+				return nil
+			}
+			return room.ErrNoHintAnswer
+		case err := <-readErr:
+			if len(h.answers) > 0 {
+				return nil
+			}
+			return fmt.Errorf("unable to read hint answer: %w", err)
+		case b := <-frames:
+			msgs, err := h.client.parse(b)
+			if err != nil {
+				return err
+			}
+
+			answered := len(h.answers)
+			for _, m := range msgs {
+				err = h.handle(ctx, m)
+				if err != nil {
+					return err
+				}
+			}
+			if len(h.answers) > answered {
+				quiet = time.After(hintQuiet)
+			}
+		}
+	}
+}
+
+func (h *hintSession) handle(ctx context.Context, m RawMsg) error {
+	switch m.Type {
+	case CmdRoomInfo:
+		return h.write(ctx, h.client.connectMessage(h.slot))
+	case CmdConnectionRefused:
+		out := &ConnectionRefusedMessage{}
+		err := json.Unmarshal(m.Payload, &out)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("server refused to connect as %s: %v", h.slot, out.Errors)
+	case CmdConnected:
+		out := &ConnectedMessage{}
+		err := json.Unmarshal(m.Payload, &out)
+		if err != nil {
+			return err
+		}
+		h.slotID = out.Slot
+		return h.write(ctx, SayMessage{
+			Cmd:  CmdSay.String(),
+			Text: fmt.Sprintf("!hint %s", h.item),
+		})
+	case CmdPrintJSON:
+		out := &PrintJSONMessage{}
+		err := json.Unmarshal(m.Payload, &out)
+		if err != nil {
+			return err
+		}
+		h.answer(out)
+		return nil
+	default:
+		return nil
+	}
+}
+
+// answer keeps the packets that are replies to the hint request and ignores the rest of the room's chatter
+func (h *hintSession) answer(out *PrintJSONMessage) {
+	if h.slotID < 0 {
+		return
+	}
+
+	var msg queue.BroadcastMessage
+	switch out.Type {
+	case JSONDataTypeHint:
+		if out.Receiving != h.slotID {
+			return
+		}
+		msg = h.client.itemEvent(out)
+	case JSONDataTypeCommandResult:
+		// points left, unknown item names and the like come back only to our client. Untyped text can be
+		// anything the server broadcasts, so it doesn't count as an answer.
+		msg = queue.BroadcastMessage{
+			Type: queue.EventText,
+		}
+	default:
+		return
+	}
+
+	msg.Room = h.client.room
+	msg.Parts = h.client.dataCache.renderJSONData(out.Data)
+	h.answers = append(h.answers, msg)
+}
+
+func (h *hintSession) write(ctx context.Context, msg any) error {
+	err := wsjson.Write(ctx, h.socket, []any{msg})
+	if err != nil {
+		return fmt.Errorf("unable to send hint request: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package multiworld
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+const hintsKeyPrefix = "_read_hints_"
+
+// hintKey identifies a hint, a location only ever holds one item so the finder and location are enough
+type hintKey struct {
+	finder   int
+	location int
+}
+
+func hintsKey(team int, slot int) string {
+	return fmt.Sprintf("%s%d_%d", hintsKeyPrefix, team, slot)
+}
+
+// recordHint adds a hint to the table and reports whether it is worth announcing, which is when it is
+// new or has just been found. The same hint turns up in both the finder's and the receiver's key.
+func (s *roomState) recordHint(h Hint) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := hintKey{finder: h.FindingPlayer, location: h.Location}
+	old, ok := s.hints[key]
+	if ok && (old.Found || !h.Found) {
+		return false
+	}
+
+	if h.Entrance == "" {
+		h.Entrance = old.Entrance
+	}
+	s.hints[key] = h
+	return true
+}
+
+// hintsFor is every hint that hasn't been found yet where the slot is either the finder or the receiver
+func (s *roomState) hintsFor(slot int) []Hint {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var out []Hint
+	for _, h := range s.hints {
+		if h.Found || (h.FindingPlayer != slot && h.ReceivingPlayer != slot) {
+			continue
+		}
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ReceivingPlayer != out[j].ReceivingPlayer {
+			return out[i].ReceivingPlayer < out[j].ReceivingPlayer
+		}
+		return out[i].Location < out[j].Location
+	})
+
+	return out
+}
+
+// storeHints adds every hint in a slot's key to the table, announcing the new and newly found ones
+func (a *ArchipelagoClient) storeHints(key string, value json.RawMessage, announce bool) error {
+	hints, err := parseHints(key, value)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hints {
+		if a.state.recordHint(h) && announce {
+			a.publisher.EnqueueMessage(a.hintEvent(h))
+		}
+	}
+
+	return nil
+}
+
+func parseHints(key string, value json.RawMessage) ([]Hint, error) {
+	// a slot that has never had a hint has no value at all
+	if len(value) == 0 || string(value) == "null" {
+		return nil, nil
+	}
+
+	var hints []Hint
+	err := json.Unmarshal(value, &hints)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %s: %w", key, err)
+	}
+
+	return hints, nil
+}
+
+// hintEvent is the announcement for a hint from data storage, it reads the same as a Hint PrintJSON
+func (a *ArchipelagoClient) hintEvent(h Hint) queue.BroadcastMessage {
+	return queue.BroadcastMessage{
+		Room:         a.room,
+		Type:         queue.EventHint,
+		Sender:       a.dataCache.GetPlayerNameForSlot(h.FindingPlayer),
+		Receiver:     a.dataCache.GetPlayerNameForSlot(h.ReceivingPlayer),
+		Item:         a.dataCache.GetItemNameForIDForPlayer(h.Item, h.ReceivingPlayer),
+		Location:     a.dataCache.GetLocationNameForIDForPlayer(h.Location, h.FindingPlayer),
+		Entrance:     h.Entrance,
+		Importance:   h.ItemFlags,
+		SenderGame:   a.dataCache.GetGameForSlot(h.FindingPlayer),
+		ReceiverGame: a.dataCache.GetGameForSlot(h.ReceivingPlayer),
+		Found:        h.Found,
+	}
+}
+
+// Hints is every hint involving a slot that hasn't been found yet, as the messages that would announce them
+func (a *ArchipelagoClient) Hints(slot int) []queue.BroadcastMessage {
+	var out []queue.BroadcastMessage
+	for _, h := range a.state.hintsFor(slot) {
+		out = append(out, a.hintEvent(h))
+	}
+
+	return out
+}
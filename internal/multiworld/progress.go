@@ -0,0 +1,296 @@
+package multiworld
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/queue"
+	"github.com/civilrights3/go-derek-go/internal/room"
+)
+
+const (
+	clientStatusKeyPrefix = "_read_client_status_"
+	// progressDir is kept in the cache directory, next to the games
+	progressDir = "progress"
+)
+
+func clientStatusKey(team int, slot int) string {
+	return fmt.Sprintf("%s%d_%d", clientStatusKeyPrefix, team, slot)
+}
+
+// clientStatusSlot is the slot a client status key belongs to
+func clientStatusSlot(key string) (int, bool) {
+	var team, slot int
+	_, err := fmt.Sscanf(strings.TrimPrefix(key, clientStatusKeyPrefix), "%d_%d", &team, &slot)
+	return slot, err == nil
+}
+
+// setLocations records our own slot's checks, which the server sends in full on connect. The milestones
+// already passed are marked as reached so reconnecting doesn't announce them again.
+func (s *roomState) setLocations(checked []int, missing []int, totals map[int]int, steps []int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	locations := make(map[int]bool)
+	for _, l := range checked {
+		locations[l] = true
+	}
+	for l := range s.checked[s.slot] {
+		locations[l] = true
+	}
+	s.checked[s.slot] = locations
+
+	s.totals = totals
+	s.totals[s.slot] = len(checked) + len(missing)
+
+	step, ok := s.nextMilestone(s.slot, steps)
+	if ok {
+		s.milestones[s.slot] = step
+	}
+}
+
+// nextMilestone is the highest step the slot has passed that hasn't been announced yet. It must be
+// called with the lock held.
+func (s *roomState) nextMilestone(slot int, steps []int) (int, bool) {
+	total := s.totals[slot]
+	if total == 0 {
+		return 0, false
+	}
+
+	pct := len(s.checked[slot]) * 100 / total
+	best, found := 0, false
+	for _, step := range steps {
+		if step <= pct && step > s.milestones[slot] && step > best {
+			best, found = step, true
+		}
+	}
+
+	return best, found
+}
+
+// milestone marks the slot's next milestone as announced, returning false when it hasn't passed one
+func (s *roomState) milestone(slot int, steps []int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	step, ok := s.nextMilestone(slot, steps)
+	if ok {
+		s.milestones[slot] = step
+	}
+
+	return ok
+}
+
+// setClientStatus records a slot's status and returns true when it means the slot has just finished its goal
+func (s *roomState) setClientStatus(slot int, status room.ClientStatus) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.statuses[slot] = status
+	if status != room.ClientGoal || s.goals[slot] {
+		return false
+	}
+
+	s.goals[slot] = true
+	return true
+}
+
+// markGoal records a slot finishing, it returns false when that was already known
+func (s *roomState) markGoal(slot int) bool {
+	return s.setClientStatus(slot, room.ClientGoal)
+}
+
+// goalProgress is how many players have finished out of how many there are, spectators and groups don't count
+func (s *roomState) goalProgress() (int, int) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	done, players := 0, 0
+	for id, info := range s.slotInfo {
+		if info.Type != slotTypePlayer {
+			continue
+		}
+		players++
+		if s.goals[id] {
+			done++
+		}
+	}
+
+	return done, players
+}
+
+func (s *roomState) progress(slot int) (int, int) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.checked[slot]), s.totals[slot]
+}
+
+// locationTotals is the number of locations for every slot in the room. Only the game's full list is known for
+// other slots, so any optional locations their settings turned off are counted too and milestones come late.
+func (a *ArchipelagoClient) locationTotals(info map[string]SlotInfo) map[int]int {
+	totals := make(map[int]int)
+	for id, i := range info {
+		slot, err := strconv.Atoi(id)
+		if err != nil {
+			continue
+		}
+		totals[slot] = a.dataCache.locationCount(i.Game)
+	}
+
+	return totals
+}
+
+// checkProgress announces a slot passing a milestone. Checks from before the bot first saw the room are only
+// known for its own slot, so for everyone else the announcement can come late but never early.
+func (a *ArchipelagoClient) checkProgress(slot int) {
+	if !a.state.milestone(slot, a.milestones) {
+		return
+	}
+	// saved straight away so the milestone isn't announced again after a crash
+	a.saveProgress()
+
+	checked, total := a.state.progress(slot)
+	a.publisher.EnqueueMessage(queue.BroadcastMessage{
+		Room:       a.room,
+		Type:       queue.EventProgress,
+		Player:     a.dataCache.GetPlayerNameForSlot(slot),
+		SenderGame: a.dataCache.GetGameForSlot(slot),
+		Count:      checked,
+		Total:      total,
+	})
+}
+
+// goalEvent is the announcement for a slot that has just finished, for when the status key changes before the
+// Goal PrintJSON arrives
+func (a *ArchipelagoClient) goalEvent(slot int) queue.BroadcastMessage {
+	done, players := a.state.goalProgress()
+	return queue.BroadcastMessage{
+		Room:       a.room,
+		Type:       queue.EventGoal,
+		Player:     a.dataCache.GetPlayerNameForSlot(slot),
+		SenderGame: a.dataCache.GetGameForSlot(slot),
+		Count:      done,
+		Total:      players,
+	}
+}
+
+// savedProgress is what is kept of a room between restarts. Only the bot's own slot can be asked for its checks,
+// so without it everyone else would be counted from nothing and their milestones announced again.
+type savedProgress struct {
+	Since      time.Time     `json:"since"`
+	Checked    map[int][]int `json:"checked"`
+	Milestones map[int]int   `json:"milestones"`
+}
+
+// saved is the progress worth keeping
+func (s *roomState) saved() savedProgress {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	p := savedProgress{
+		Since:      s.since,
+		Checked:    make(map[int][]int),
+		Milestones: make(map[int]int),
+	}
+	for slot, locations := range s.checked {
+		for l := range locations {
+			p.Checked[slot] = append(p.Checked[slot], l)
+		}
+	}
+	for slot, step := range s.milestones {
+		p.Milestones[slot] = step
+	}
+
+	return p
+}
+
+// restore adds the progress from an earlier run to what has been seen so far
+func (s *roomState) restore(p savedProgress) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !p.Since.IsZero() && p.Since.Before(s.since) {
+		s.since = p.Since
+	}
+	for slot, checked := range p.Checked {
+		locations, ok := s.checked[slot]
+		if !ok {
+			locations = make(map[int]bool)
+			s.checked[slot] = locations
+		}
+		for _, l := range checked {
+			locations[l] = true
+		}
+	}
+	for slot, step := range p.Milestones {
+		if step > s.milestones[slot] {
+			s.milestones[slot] = step
+		}
+	}
+}
+
+// loadProgress picks up where an earlier run left off in the room, the file is named after the seed so a new
+// game at the same address starts afresh
+func (a *ArchipelagoClient) loadProgress(seed string) {
+	if seed == "" || filepath.Base(seed) != seed {
+		return
+	}
+
+	file := filepath.Join(a.progressDir, seed+".json")
+	if file == a.progressFile {
+		return
+	}
+	a.progressFile = file
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("unable to read progress for %s: %s\n", seed, err)
+		return
+	}
+
+	p := savedProgress{}
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		fmt.Printf("unable to unmarshal progress for %s: %s\n", seed, err)
+		return
+	}
+	a.state.restore(p)
+}
+
+// saveProgress writes the room's progress out, a failure only means the next restart counts from less
+func (a *ArchipelagoClient) saveProgress() {
+	if a.progressFile == "" {
+		return
+	}
+
+	b, err := json.Marshal(a.state.saved())
+	if err != nil {
+		fmt.Printf("unable to marshal progress: %s\n", err)
+		return
+	}
+
+	err = os.MkdirAll(a.progressDir, fs.ModePerm)
+	if err != nil {
+		fmt.Printf("unable to create progress directory: %s\n", err)
+		return
+	}
+
+	// written next to the file and renamed over it, so a crash part way through doesn't lose what was there
+	tmp := a.progressFile + ".tmp"
+	err = os.WriteFile(tmp, b, fs.ModePerm)
+	if err == nil {
+		err = os.Rename(tmp, a.progressFile)
+	}
+	if err != nil {
+		fmt.Printf("unable to save progress: %s\n", err)
+	}
+}
@@ -0,0 +1,64 @@
+package multiworld
+
+import (
+	"testing"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+)
+
+// progressClient is a client for a room with another slot of ten locations, as if it had just connected
+func progressClient(t *testing.T, cache string, seed string) *ArchipelagoClient {
+	t.Helper()
+	cfg := config.NewDefaultConfig().Multiworld
+	cfg.Cache.Filepath = cache
+	cfg.ProgressMilestones = []int{25, 50, 75, 100}
+	a, err := NewArchipelagoClient(cfg, config.World{Name: "test", Server: "localhost:38281"}, nil, discardPublisher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.state.totals = map[int]int{2: 10}
+	a.loadProgress(seed)
+	return a
+}
+
+// check marks the other slot's location as checked, returning whether a milestone was passed
+func check(a *ArchipelagoClient, location int) bool {
+	a.state.lock.Lock()
+	if a.state.checked[2] == nil {
+		a.state.checked[2] = make(map[int]bool)
+	}
+	a.state.checked[2][location] = true
+	a.state.lock.Unlock()
+
+	return a.state.milestone(2, a.milestones)
+}
+
+func TestProgressCarriesOverARestart(t *testing.T) {
+	cache := t.TempDir()
+	a := progressClient(t, cache, "12345")
+	for l := 1; l <= 3; l++ {
+		check(a, l)
+	}
+	a.saveProgress()
+
+	// after a restart the same checks come in again, along with the ones made while the bot was away
+	a = progressClient(t, cache, "12345")
+	for l := 1; l <= 4; l++ {
+		if check(a, l) {
+			t.Errorf("check %d announced 25%% again", l)
+		}
+	}
+	if !check(a, 5) {
+		t.Error("passing 50% wasn't announced")
+	}
+	if checked, total := a.state.progress(2); checked != 5 || total != 10 {
+		t.Errorf("got %d of %d checks, want 5 of 10", checked, total)
+	}
+
+	// a new game at the same address starts from nothing
+	a = progressClient(t, cache, "67890")
+	if checked, _ := a.state.progress(2); checked != 0 {
+		t.Errorf("a new seed starts with %d checks", checked)
+	}
+}
@@ -0,0 +1,195 @@
+package multiworld
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/queue"
+	"github.com/civilrights3/go-derek-go/internal/room"
+)
+
+const slotTypePlayer = 1
+
+// roomState is everything tracked about the room, it is written by the read loop and read by chat commands
+type roomState struct {
+	lock          sync.RWMutex
+	connected     bool
+	team          int
+	slot          int
+	players       map[int]Player
+	slotInfo      map[int]SlotInfo
+	checked       map[int]map[int]bool
+	itemsReceived map[int]int
+	totals        map[int]int
+	statuses      map[int]room.ClientStatus
+	goals         map[int]bool
+	// milestones is the highest progress milestone announced for each slot
+	milestones map[int]int
+	hintPoints int
+	stats      map[int]*slotStats
+	// finished is set once the final report has gone out
+	finished bool
+	// hints is every hint on the team, from data storage and the Hint PrintJSONs
+	hints map[hintKey]Hint
+	since time.Time
+}
+
+func newRoomState() *roomState {
+	return &roomState{
+		players:       make(map[int]Player),
+		slotInfo:      make(map[int]SlotInfo),
+		checked:       make(map[int]map[int]bool),
+		itemsReceived: make(map[int]int),
+		totals:        make(map[int]int),
+		statuses:      make(map[int]room.ClientStatus),
+		goals:         make(map[int]bool),
+		milestones:    make(map[int]int),
+		stats:         make(map[int]*slotStats),
+		hints:         make(map[hintKey]Hint),
+		since:         time.Now(),
+	}
+}
+
+func (s *roomState) ownSlot() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.slot
+}
+
+func (s *roomState) setConnected(connected bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.connected = connected
+}
+
+func (s *roomState) setPlayers(team int, slot int, hintPoints int, players []Player, info map[string]SlotInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.connected = true
+	s.team = team
+	s.slot = slot
+	s.hintPoints = hintPoints
+	s.updatePlayers(players)
+
+	s.slotInfo = make(map[int]SlotInfo)
+	for id, i := range info {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			continue
+		}
+		s.slotInfo[n] = i
+	}
+}
+
+// updatePlayers must be called with the lock held
+func (s *roomState) updatePlayers(players []Player) {
+	if len(players) == 0 {
+		return
+	}
+
+	s.players = make(map[int]Player)
+	for _, p := range players {
+		s.players[p.Slot] = p
+	}
+}
+
+// roomUpdate applies whatever changed and returns true when our own slot checked something new
+func (s *roomState) roomUpdate(out *RoomUpdateMessage) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.updatePlayers(out.Players)
+	if out.HintPoints != nil {
+		s.hintPoints = *out.HintPoints
+	}
+
+	locations, ok := s.checked[s.slot]
+	if !ok {
+		locations = make(map[int]bool)
+		s.checked[s.slot] = locations
+	}
+
+	checked := false
+	for _, l := range out.CheckedLocations {
+		if !locations[l] {
+			locations[l] = true
+			s.recordCheck(s.slot, time.Now())
+			checked = true
+		}
+	}
+
+	return checked
+}
+
+// itemSent records the check that found an item and who it went to, it returns false for a check already seen
+func (s *roomState) itemSent(finder int, location int, receiver int, flags queue.ItemImportanceFlag) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	locations, ok := s.checked[finder]
+	if !ok {
+		locations = make(map[int]bool)
+		s.checked[finder] = locations
+	}
+	if locations[location] {
+		return false
+	}
+
+	locations[location] = true
+	s.recordItem(finder, receiver, flags)
+	s.recordCheck(finder, time.Now())
+	if receiver != s.slot {
+		// our own slot is counted from ReceivedItems, which includes everything from before we connected
+		s.itemsReceived[receiver]++
+	}
+	return true
+}
+
+// receivedItems records the items sent to our own slot, index is where the packet starts in the full list
+func (s *roomState) receivedItems(index int, count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if index+count > s.itemsReceived[s.slot] {
+		s.itemsReceived[s.slot] = index + count
+	}
+}
+
+func (s *roomState) status(name string, address string, slotName string) room.Status {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	status := room.Status{
+		Room:       name,
+		Address:    address,
+		Connected:  s.connected,
+		Slot:       slotName,
+		HintPoints: s.hintPoints,
+		Since:      s.since,
+	}
+
+	for id, info := range s.slotInfo {
+		p := s.players[id]
+		status.Players = append(status.Players, room.Player{
+			Team:          p.Team,
+			Slot:          id,
+			Name:          info.Name,
+			Alias:         p.Alias,
+			Game:          info.Game,
+			IsPlayer:      info.Type == slotTypePlayer,
+			Status:        s.statuses[id],
+			Goal:          s.goals[id],
+			Checked:       len(s.checked[id]),
+			Total:         s.totals[id],
+			ItemsReceived: s.itemsReceived[id],
+		})
+	}
+	sort.Slice(status.Players, func(i, j int) bool {
+		return status.Players[i].Slot < status.Players[j].Slot
+	})
+
+	return status
+}
@@ -0,0 +1,174 @@
+package multiworld
+
+import (
+	"sort"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
+
+// slotStats is what a slot has done since the bot started tracking the room
+type slotStats struct {
+	sent     map[string]int
+	received map[string]int
+	// timedChecks are the checks seen as they happened, only those can be used for the time between checks
+	timedChecks int
+	firstCheck  time.Time
+	lastCheck   time.Time
+	longestGap  time.Duration
+}
+
+// statsFor must be called with the lock held
+func (s *roomState) statsFor(slot int) *slotStats {
+	st, ok := s.stats[slot]
+	if !ok {
+		st = &slotStats{
+			sent:     make(map[string]int),
+			received: make(map[string]int),
+		}
+		s.stats[slot] = st
+	}
+
+	return st
+}
+
+// recordItem counts an item moving from the finder to the receiver. It must be called with the lock held.
+func (s *roomState) recordItem(finder int, receiver int, flags queue.ItemImportanceFlag) {
+	if finder != receiver {
+		s.statsFor(finder).sent[flags.Name()]++
+	}
+	s.statsFor(receiver).received[flags.Name()]++
+}
+
+// recordCheck times a check as it happened. It must be called with the lock held.
+func (s *roomState) recordCheck(slot int, at time.Time) {
+	st := s.statsFor(slot)
+	if st.timedChecks > 0 {
+		gap := at.Sub(st.lastCheck)
+		if gap > st.longestGap {
+			st.longestGap = gap
+		}
+	} else {
+		st.firstCheck = at
+	}
+
+	st.timedChecks++
+	st.lastCheck = at
+}
+
+func (s *roomState) itemCheat(receiver int, flags queue.ItemImportanceFlag) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.statsFor(receiver).received[flags.Name()]++
+}
+
+// finish returns true the first time every player is done, so the final report only goes out once
+func (s *roomState) finish() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.finished {
+		return false
+	}
+
+	players := 0
+	for id, info := range s.slotInfo {
+		if info.Type != slotTypePlayer {
+			continue
+		}
+		players++
+		if !s.goals[id] {
+			return false
+		}
+	}
+	if players == 0 {
+		return false
+	}
+
+	s.finished = true
+	return true
+}
+
+func (s *roomState) summary() []queue.SlotSummary {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	hints := make(map[int]int)
+	for _, h := range s.hints {
+		// the receiver is the one who paid for the hint
+		hints[h.ReceivingPlayer]++
+	}
+
+	var ids []int
+	for id, info := range s.slotInfo {
+		if info.Type == slotTypePlayer {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	out := make([]queue.SlotSummary, 0, len(ids))
+	for _, id := range ids {
+		summary := queue.SlotSummary{
+			Name:     s.slotInfo[id].Name,
+			Game:     s.slotInfo[id].Game,
+			Goal:     s.goals[id],
+			Checks:   len(s.checked[id]),
+			Total:    s.totals[id],
+			Sent:     make(map[string]int),
+			Received: make(map[string]int),
+			Hints:    hints[id],
+		}
+
+		st, ok := s.stats[id]
+		if ok {
+			for k, v := range st.sent {
+				summary.Sent[k] = v
+			}
+			for k, v := range st.received {
+				summary.Received[k] = v
+			}
+			summary.LongestGap = st.longestGap
+			if st.timedChecks > 1 {
+				summary.AverageGap = st.lastCheck.Sub(st.firstCheck) / time.Duration(st.timedChecks-1)
+			}
+		}
+
+		out = append(out, summary)
+	}
+
+	return out
+}
+
+// Summary is the report of every player in the room as it stands
+func (a *ArchipelagoClient) Summary() queue.BroadcastMessage {
+	done, players := a.state.goalProgress()
+	return queue.BroadcastMessage{
+		Room:    a.room,
+		Type:    queue.EventDigest,
+		Count:   done,
+		Total:   players,
+		Summary: a.state.summary(),
+	}
+}
+
+// PublishDigest announces the report, it is skipped until the room's players are known
+func (a *ArchipelagoClient) PublishDigest() {
+	msg := a.Summary()
+	if len(msg.Summary) == 0 {
+		return
+	}
+
+	a.publisher.EnqueueMessage(msg)
+}
+
+// checkFinished sends the final report once the last player finishes their goal
+func (a *ArchipelagoClient) checkFinished() {
+	if !a.state.finish() {
+		return
+	}
+
+	msg := a.Summary()
+	msg.Type = queue.EventFinalReport
+	a.publisher.EnqueueMessage(msg)
+}
@@ -0,0 +1,117 @@
+package multiworld
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/civilrights3/go-derek-go/internal/room"
+)
+
+// watchedKeys is every data storage key the client follows for the slots on its team
+func (s *roomState) watchedKeys() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var keys []string
+	for _, p := range s.players {
+		if p.Team != s.team {
+			continue
+		}
+		keys = append(keys, hintsKey(p.Team, p.Slot), clientStatusKey(p.Team, p.Slot))
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// sendWatch fetches the current value of every watched key and asks to be told when they change
+func (a *ArchipelagoClient) sendWatch() error {
+	keys := a.state.watchedKeys()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	err := a.send(GetMessage{
+		Cmd:  CmdGet.String(),
+		Keys: keys,
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.send(SetNotifyMessage{
+		Cmd:  CmdSetNotify.String(),
+		Keys: keys,
+	})
+}
+
+// handleRetrieved loads the current values. Nothing is announced, anything that happened while
+// the bot was away is already old news.
+func (a *ArchipelagoClient) handleRetrieved(_ context.Context, b []byte) error {
+	out := &RetrievedMessage{}
+	err := json.Unmarshal(b, &out)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	for key, value := range out.Keys {
+		err = a.storeKey(key, value, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *ArchipelagoClient) handleSetReply(_ context.Context, b []byte) error {
+	out := &SetReplyMessage{}
+	err := json.Unmarshal(b, &out)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return a.storeKey(out.Key, out.Value, true)
+}
+
+// storeKey takes in the value of a watched key, announcing what changed when asked to
+func (a *ArchipelagoClient) storeKey(key string, value json.RawMessage, announce bool) error {
+	switch {
+	case strings.HasPrefix(key, hintsKeyPrefix):
+		return a.storeHints(key, value, announce)
+	case strings.HasPrefix(key, clientStatusKeyPrefix):
+		return a.storeClientStatus(key, value, announce)
+	default:
+		return nil
+	}
+}
+
+func (a *ArchipelagoClient) storeClientStatus(key string, value json.RawMessage, announce bool) error {
+	slot, ok := clientStatusSlot(key)
+	if !ok {
+		return fmt.Errorf("unexpected client status key %s", key)
+	}
+
+	// a slot that has never connected has no value at all
+	if len(value) == 0 || string(value) == "null" {
+		return nil
+	}
+
+	var status room.ClientStatus
+	err := json.Unmarshal(value, &status)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshal %s: %w", key, err)
+	}
+
+	if a.state.setClientStatus(slot, status) && announce {
+		a.publisher.EnqueueMessage(a.goalEvent(slot))
+		a.checkFinished()
+	}
+
+	return nil
+}
@@ -1,6 +1,10 @@
 package multiworld
 
-import "github.com/civilrights3/go-derek-go/internal/queue"
+import (
+	"encoding/json"
+
+	"github.com/civilrights3/go-derek-go/internal/queue"
+)
 
 type RawMsg struct {
 	Type    ServerMessageType
@@ -21,6 +25,7 @@ const (
 	CmdInvalidPacket     ServerMessageType = "InvalidPacket"
 	CmdBounced           ServerMessageType = "Bounced"
 	CmdSetReply          ServerMessageType = "SetReply"
+	CmdRetrieved         ServerMessageType = "Retrieved"
 )
 
 func (s ServerMessageType) String() string {
@@ -55,6 +60,7 @@ type Message struct {
 
 type RoomInfoMessage struct {
 	Version             Version           `json:"version"`
+	SeedName            string            `json:"seed_name"`
 	PasswordReqd        bool              `json:"password"`
 	DataPackageChecksum map[string]string `json:"datapackage_checksums"`
 	Games               []string          `json:"games"`
@@ -71,12 +77,86 @@ type ConnectMessage struct {
 	Game          string   `json:"game"`
 }
 
+const RefusedInvalidPassword = "InvalidPassword"
+
+type ConnectionRefusedMessage struct {
+	Cmd    string   `json:"cmd"`
+	Errors []string `json:"errors"`
+}
+
+// InvalidPacketMessage is the server saying it couldn't make sense of a packet the bot sent
+type InvalidPacketMessage struct {
+	Cmd         string `json:"cmd"`
+	Type        string `json:"type"`
+	OriginalCmd string `json:"original_cmd"`
+	Text        string `json:"text"`
+}
+
 type ConnectedMessage struct {
-	Cmd      string              `json:"cmd"`
-	Team     int                 `json:"team"`
-	Slot     int                 `json:"slot"`
-	Players  []Player            `json:"players"`
-	SlotInfo map[string]SlotInfo `json:"slot_info"`
+	Cmd              string              `json:"cmd"`
+	Team             int                 `json:"team"`
+	Slot             int                 `json:"slot"`
+	Players          []Player            `json:"players"`
+	SlotInfo         map[string]SlotInfo `json:"slot_info"`
+	CheckedLocations []int               `json:"checked_locations"`
+	MissingLocations []int               `json:"missing_locations"`
+	HintPoints       int                 `json:"hint_points"`
+}
+
+// RoomUpdateMessage only carries the fields that changed, anything missing is left as it was
+type RoomUpdateMessage struct {
+	Cmd     string   `json:"cmd"`
+	Players []Player `json:"players"`
+	// CheckedLocations is only the locations newly checked by our own slot
+	CheckedLocations []int `json:"checked_locations"`
+	HintPoints       *int  `json:"hint_points"`
+}
+
+type ReceivedItemsMessage struct {
+	Cmd   string     `json:"cmd"`
+	Index int        `json:"index"`
+	Items []JSONItem `json:"items"`
+}
+
+type SayMessage struct {
+	Cmd  string `json:"cmd"`
+	Text string `json:"text"`
+}
+
+type GetMessage struct {
+	Cmd  string   `json:"cmd"`
+	Keys []string `json:"keys"`
+}
+
+type SetNotifyMessage struct {
+	Cmd  string   `json:"cmd"`
+	Keys []string `json:"keys"`
+}
+
+// RetrievedMessage answers a Get, values are left raw since every key holds something different
+type RetrievedMessage struct {
+	Cmd  string                     `json:"cmd"`
+	Keys map[string]json.RawMessage `json:"keys"`
+}
+
+// SetReplyMessage is sent whenever a key we asked to be notified about changes
+type SetReplyMessage struct {
+	Cmd           string          `json:"cmd"`
+	Key           string          `json:"key"`
+	Value         json.RawMessage `json:"value"`
+	OriginalValue json.RawMessage `json:"original_value"`
+	Slot          int             `json:"slot"`
+}
+
+// Hint is an entry in a slot's _read_hints data storage key
+type Hint struct {
+	ReceivingPlayer int                      `json:"receiving_player"`
+	FindingPlayer   int                      `json:"finding_player"`
+	Location        int                      `json:"location"`
+	Item            int                      `json:"item"`
+	Found           bool                     `json:"found"`
+	Entrance        string                   `json:"entrance"`
+	ItemFlags       queue.ItemImportanceFlag `json:"item_flags"`
 }
 
 type GetDataPackageMessage struct {
@@ -114,7 +194,21 @@ type SlotInfo struct {
 	Class string `json:"class"`
 }
 
-const JSONDataTypeItemSend = "ItemSend"
+const (
+	JSONDataTypeItemSend      = "ItemSend"
+	JSONDataTypeItemCheat     = "ItemCheat"
+	JSONDataTypeHint          = "Hint"
+	JSONDataTypeJoin          = "Join"
+	JSONDataTypePart          = "Part"
+	JSONDataTypeChat          = "Chat"
+	JSONDataTypeServerChat    = "ServerChat"
+	JSONDataTypeGoal          = "Goal"
+	JSONDataTypeRelease       = "Release"
+	JSONDataTypeCollect       = "Collect"
+	JSONDataTypeCountdown     = "Countdown"
+	JSONDataTypeTagsChanged   = "TagsChanged"
+	JSONDataTypeCommandResult = "CommandResult"
+)
 
 type PrintJSONMessage struct {
 	Cmd       string            `json:"cmd"`
@@ -122,21 +216,34 @@ type PrintJSONMessage struct {
 	Type      string            `json:"type"`
 	Item      JSONItem          `json:"item"`
 	Receiving int               `json:"receiving"`
+	Team      int               `json:"team"`
+	Slot      int               `json:"slot"`
+	Found     bool              `json:"found"`
+	Message   string            `json:"message"`
+	Countdown int               `json:"countdown"`
+	Tags      []string          `json:"tags"`
 }
 
 type JsonDataItemType string
 
 const (
-	DataTypePlayerID   JsonDataItemType = "player_id"
-	DataTypeItemID     JsonDataItemType = "item_id"
-	DataTypeLocationID JsonDataItemType = "location_id"
+	DataTypeText         JsonDataItemType = "text"
+	DataTypePlayerID     JsonDataItemType = "player_id"
+	DataTypePlayerName   JsonDataItemType = "player_name"
+	DataTypeItemID       JsonDataItemType = "item_id"
+	DataTypeItemName     JsonDataItemType = "item_name"
+	DataTypeLocationID   JsonDataItemType = "location_id"
+	DataTypeLocationName JsonDataItemType = "location_name"
+	DataTypeEntranceName JsonDataItemType = "entrance_name"
+	DataTypeColor        JsonDataItemType = "color"
 )
 
 type JSONDataElement struct {
-	Text   string           `json:"text"`
-	Player int              `json:"Player"`
-	Flags  int              `json:"flags"`
-	Type   JsonDataItemType `json:"type"`
+	Text   string                   `json:"text"`
+	Player int                      `json:"Player"`
+	Flags  queue.ItemImportanceFlag `json:"flags"`
+	Type   JsonDataItemType         `json:"type"`
+	Color  string                   `json:"color"`
 }
 
 type JSONItem struct {
@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// deadLetterStore appends batches that a listener kept failing on to a JSON lines file, so they can be
+// looked at and replayed by hand
+type deadLetterStore struct {
+	path string
+	lock sync.Mutex
+}
+
+type deadLetter struct {
+	Time     time.Time          `json:"time"`
+	Listener string             `json:"listener"`
+	Error    string             `json:"error"`
+	Messages []BroadcastMessage `json:"messages"`
+}
+
+func newDeadLetterStore(path string) *deadLetterStore {
+	return &deadLetterStore{
+		path: path,
+	}
+}
+
+func (d *deadLetterStore) write(listener string, messages []BroadcastMessage, cause error) error {
+	if d.path == "" {
+		return fmt.Errorf("no dead letter file configured")
+	}
+
+	b, err := json.Marshal(deadLetter{
+		Time:     time.Now(),
+		Listener: listener,
+		Error:    cause.Error(),
+		Messages: messages,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to marshal dead letter: %w", err)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(d.path), fs.ModePerm)
+	if err != nil {
+		return fmt.Errorf("cannot create dead letter directory: %w", err)
+	}
+
+	f, err := os.OpenFile(d.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fs.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to open dead letter file: %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("unable to write dead letter: %w", err)
+	}
+
+	return nil
+}
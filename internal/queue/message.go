@@ -0,0 +1,123 @@
+package queue
+
+import "time"
+
+type ItemImportanceFlag int
+
+const (
+	ItemNormal      ItemImportanceFlag = 0
+	ItemProgression ItemImportanceFlag = 0b001
+	ItemHelpful     ItemImportanceFlag = 0b010
+	ItemTrap        ItemImportanceFlag = 0b100
+)
+
+// Name is what the flags count as when only one can be picked, the most important flag wins
+func (f ItemImportanceFlag) Name() string {
+	switch {
+	case f&ItemTrap != 0:
+		return "trap"
+	case f&ItemProgression != 0:
+		return "progression"
+	case f&ItemHelpful != 0:
+		return "helpful"
+	default:
+		return "normal"
+	}
+}
+
+// EventType is the kind of room event a BroadcastMessage describes, named after the PrintJSON type it came from
+type EventType string
+
+const (
+	EventItemSend    EventType = "ItemSend"
+	EventItemCheat   EventType = "ItemCheat"
+	EventHint        EventType = "Hint"
+	EventJoin        EventType = "Join"
+	EventPart        EventType = "Part"
+	EventChat        EventType = "Chat"
+	EventServerChat  EventType = "ServerChat"
+	EventGoal        EventType = "Goal"
+	EventRelease     EventType = "Release"
+	EventCollect     EventType = "Collect"
+	EventCountdown   EventType = "Countdown"
+	EventTagsChanged EventType = "TagsChanged"
+	// EventText is any other server text, it is only shown through its Parts
+	EventText EventType = "Text"
+	// EventProgress is a player passing a milestone of their checks, it has no PrintJSON of its own
+	EventProgress EventType = "Progress"
+	// EventDigest and EventFinalReport are the statistics of every slot, on a schedule or once everyone is done
+	EventDigest      EventType = "Digest"
+	EventFinalReport EventType = "FinalReport"
+)
+
+// TextPartType is what a piece of server text refers to once it has been resolved to a name
+type TextPartType string
+
+const (
+	TextPlain    TextPartType = "text"
+	TextPlayer   TextPartType = "player"
+	TextItem     TextPartType = "item"
+	TextLocation TextPartType = "location"
+	TextEntrance TextPartType = "entrance"
+	TextColor    TextPartType = "color"
+)
+
+// TextPart is one resolved element of the data list of a PrintJSON
+type TextPart struct {
+	Type       TextPartType
+	Text       string
+	Importance ItemImportanceFlag
+	// Color is the Archipelago colour name of a TextColor part, such as red or bold
+	Color string
+}
+
+// SlotSummary is the statistics of one slot since the bot started tracking the room
+type SlotSummary struct {
+	Name   string
+	Game   string
+	Goal   bool
+	Checks int
+	Total  int
+	// Sent and Received count items by importance name, Sent only counts the items that went to someone else
+	Sent     map[string]int
+	Received map[string]int
+	Hints    int
+	// AverageGap and LongestGap are the time between checks
+	AverageGap time.Duration
+	LongestGap time.Duration
+}
+
+type BroadcastMessage struct {
+	// Seq is the position of the message in the queue, it only ever goes up
+	Seq uint64
+	// Time is when the event was enqueued
+	Time time.Time
+	// Room is the name of the room the event happened in, empty when the room has no name. A named room is
+	// always tagged, even when it is the only one, since channels, bridges and routes are picked by it.
+	Room     string
+	Type     EventType
+	Sender   string
+	Receiver string
+	Item     string
+	Location string
+	// Entrance is where a hinted location is reached from in entrance randomised games
+	Entrance   string
+	Importance ItemImportanceFlag
+	// SenderGame and ReceiverGame are the games played by the sender and receiver slots, for
+	// player events SenderGame is the game of the player
+	SenderGame   string
+	ReceiverGame string
+	// Player is who the event is about for events that don't move an item between players
+	Player    string
+	Found     bool
+	Message   string
+	Countdown int
+	Tags      []string
+	// Count and Total are how far along a milestone is, players done for a goal or locations checked for progress
+	Count int
+	Total int
+	// Summary is the statistics of every player for a report
+	Summary []SlotSummary
+	// Parts is the server's own rendering of the event, in the same order the official text client shows it
+	Parts []TextPart
+}
@@ -1,98 +1,374 @@
 package queue
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
-)
 
-const (
-	delay = 250 * time.Millisecond
+	"github.com/civilrights3/go-derek-go/internal/config"
 )
 
-var (
-	Queue *messageQueue
-)
+// Publisher is what event sources use to hand messages to a queue
+type Publisher interface {
+	EnqueueMessage(message BroadcastMessage)
+}
+
+// Subscriber is what sinks use to receive messages from a queue
+type Subscriber interface {
+	RegisterMessageListener(name string, f MessageListener)
+}
+
+// MessageListener receives every message that was waiting when the batch window closed, oldest first
+type MessageListener func(messages []BroadcastMessage) error
+
+// MessageQueue fans messages out from the publishers to every registered listener
+type MessageQueue struct {
+	// queue holds every message that at least one listener hasn't handled yet
+	queue       []BroadcastMessage
+	listeners   []*listener
+	lock        sync.RWMutex
+	batchWindow time.Duration
+	maxBatch    int
+	retry       retryPolicy
+	lastSeq     uint64
+	// store keeps the queue on disk when a queue directory is configured, otherwise it is nil
+	store       *segmentStore
+	deadLetters *deadLetterStore
+	// runCtx is set once Run is called, listeners registered after that start straight away
+	runCtx context.Context
+	wg     sync.WaitGroup
+	// flushing skips the batch window so everything goes out as soon as possible during shutdown
+	flushing bool
+	// progress is signalled whenever a listener moves forward, Drain waits on it
+	progress chan struct{}
+}
+
+// listener is a sink with its own position in the queue, so a slow or failing sink never holds up the others
+type listener struct {
+	name   string
+	send   MessageListener
+	cursor uint64
+	// wake is signalled whenever a message is enqueued, it never blocks the enqueuer
+	wake chan struct{}
+}
+
+type retryPolicy struct {
+	attempts int
+	min      time.Duration
+	max      time.Duration
+}
+
+// New builds a queue, loading anything left undelivered on disk. Nothing is delivered until Run is called.
+func New(cfg config.Queue) (*MessageQueue, error) {
+	q := &MessageQueue{
+		queue:       make([]BroadcastMessage, 0),
+		listeners:   make([]*listener, 0),
+		lock:        sync.RWMutex{},
+		batchWindow: cfg.BatchWindow,
+		maxBatch:    cfg.MaxBatch,
+		retry: retryPolicy{
+			attempts: cfg.Retry.Attempts,
+			min:      cfg.Retry.MinBackoff,
+			max:      cfg.Retry.MaxBackoff,
+		},
+		deadLetters: newDeadLetterStore(cfg.DeadLetterFile),
+		progress:    make(chan struct{}, 1),
+	}
+
+	if cfg.Dir != "" {
+		q.store = newSegmentStore(cfg.Dir)
+		pending, err := q.store.load()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load queue from %s: %w", cfg.Dir, err)
+		}
+
+		q.queue = append(q.queue, pending...)
+		q.lastSeq = q.store.lastSeq()
+		if len(pending) > 0 {
+			fmt.Printf("Recovered %d undelivered messages\n", len(pending))
+		}
+	}
+
+	return q, nil
+}
+
+// RegisterMessageListener adds a sink under a name that identifies its position in the on disk queue.
+// A sink seen before picks up where it left off, a new one starts with everything still queued.
+func (m *MessageQueue) RegisterMessageListener(name string, f MessageListener) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	l := &listener{
+		name:   name,
+		send:   f,
+		cursor: m.startingCursor(name),
+		wake:   make(chan struct{}, 1),
+	}
+	m.listeners = append(m.listeners, l)
+
+	if m.runCtx != nil {
+		m.startListener(m.runCtx, l)
+	}
+}
+
+// Run delivers messages to every listener until the context is done, then waits for the
+// listeners to finish whatever they are sending
+func (m *MessageQueue) Run(ctx context.Context) {
+	m.lock.Lock()
+	m.runCtx = ctx
+	for _, l := range m.listeners {
+		m.startListener(ctx, l)
+	}
+	m.lock.Unlock()
+
+	<-ctx.Done()
+	m.wg.Wait()
+}
 
-type messageQueue struct {
-	queue            []BroadcastMessage
-	messageListeners []func(message BroadcastMessage) error
-	lock             sync.RWMutex
+// startListener must be called with the lock held
+func (m *MessageQueue) startListener(ctx context.Context, l *listener) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.deliver(ctx, l)
+	}()
 }
 
-func StartMessageQueue() {
-	q := &messageQueue{
-		queue:            make([]BroadcastMessage, 0),
-		messageListeners: make([]func(message BroadcastMessage) error, 0),
-		lock:             sync.RWMutex{},
+func (m *MessageQueue) startingCursor(name string) uint64 {
+	if m.store != nil {
+		c, ok := m.store.cursor(name)
+		if ok {
+			return c
+		}
 	}
 
-	Queue = q
+	if len(m.queue) > 0 {
+		return m.queue[0].Seq - 1
+	}
 
-	go Queue.run()
+	return m.lastSeq
 }
 
-func (m *messageQueue) run() {
+func (m *MessageQueue) deliver(ctx context.Context, l *listener) {
 	for {
-		select {
-		case <-time.After(delay):
-			if len(m.messageListeners) > 0 && len(m.queue) > 0 {
-				msg := m.GetNext()
-				for _, l := range m.messageListeners {
-					err := l(msg)
-					if err != nil {
-						fmt.Printf("error sending message %s\n", err.Error())
-					}
+		batch, wait := m.nextBatch(l.cursor)
+		if len(batch) == 0 {
+			if !sleep(ctx, l.wake, wait) {
+				return
+			}
+			continue
+		}
+
+		err := m.sendWithRetry(ctx, l, batch)
+		if err != nil {
+			if ctx.Err() != nil {
+				// shutting down, the batch stays queued for the next start
+				return
+			}
+
+			fmt.Printf("giving up sending %d messages to %s: %s\n", len(batch), l.name, err)
+			dlErr := m.deadLetters.write(l.name, batch, err)
+			if dlErr != nil {
+				// the batch isn't written down anywhere else, so it stays queued and is tried again later
+				fmt.Printf("unable to dead letter messages, keeping them queued for %s: %s\n", l.name, dlErr)
+				if !sleep(ctx, nil, m.retry.max) {
+					return
 				}
-				m.AckNext()
+				continue
 			}
 		}
+
+		m.advance(l, batch[len(batch)-1].Seq)
 	}
 }
 
-func (m *messageQueue) RegisterMessageListener(f func(message BroadcastMessage) error) {
-	m.messageListeners = append(m.messageListeners, f)
+// sleep waits until the listener is woken, the wait is over, or the context is done. A wait of zero
+// only ends when woken. It returns false when the context is done.
+func sleep(ctx context.Context, wake <-chan struct{}, wait time.Duration) bool {
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-wake:
+		return true
+	case <-timeout:
+		return true
+	}
 }
 
-func (m *messageQueue) EnqueueMessage(message BroadcastMessage) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	m.queue = append(m.queue, message)
+// sendWithRetry retries a failing batch with exponential backoff until the attempts run out
+func (m *MessageQueue) sendWithRetry(ctx context.Context, l *listener, batch []BroadcastMessage) error {
+	backoff := m.retry.min
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = l.send(batch)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= m.retry.attempts {
+			return err
+		}
+
+		fmt.Printf("error sending message to %s (attempt %d of %d), retry in %s: %s\n", l.name, attempt, m.retry.attempts, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		backoff = backoff * 2
+		if backoff > m.retry.max {
+			backoff = m.retry.max
+		}
+	}
 }
 
-func (m *messageQueue) GetNext() BroadcastMessage {
+// nextBatch returns the messages after the cursor once the oldest of them has waited out the batch window,
+// so a burst such as a release is collected into as few posts as possible. When nothing is ready yet it
+// returns how long is left of the window, or zero when there is nothing to wait for.
+func (m *MessageQueue) nextBatch(cursor uint64) ([]BroadcastMessage, time.Duration) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
-	return m.queue[0]
+
+	start := sort.Search(len(m.queue), func(i int) bool {
+		return m.queue[i].Seq > cursor
+	})
+	pending := m.queue[start:]
+	if len(pending) == 0 {
+		return nil, 0
+	}
+
+	full := m.maxBatch > 0 && len(pending) >= m.maxBatch
+	waited := time.Since(pending[0].Time)
+	if !full && !m.flushing && waited < m.batchWindow {
+		return nil, m.batchWindow - waited
+	}
+
+	n := len(pending)
+	if m.maxBatch > 0 && n > m.maxBatch {
+		n = m.maxBatch
+	}
+
+	batch := make([]BroadcastMessage, n)
+	copy(batch, pending[:n])
+	return batch, 0
 }
 
-func (m *messageQueue) AckNext() {
+// advance moves a listener past seq and drops whatever every listener has now handled
+func (m *MessageQueue) advance(l *listener, seq uint64) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	m.queue = m.queue[1:]
+
+	l.cursor = seq
+	acked := l.cursor
+	for _, o := range m.listeners {
+		if o.cursor < acked {
+			acked = o.cursor
+		}
+	}
+
+	drop := sort.Search(len(m.queue), func(i int) bool {
+		return m.queue[i].Seq > acked
+	})
+	m.queue = m.queue[drop:]
+
+	if m.store != nil {
+		err := m.store.saveCursor(l.name, seq, acked)
+		if err != nil {
+			fmt.Printf("unable to persist ack: %s\n", err)
+		}
+	}
+
+	select {
+	case m.progress <- struct{}{}:
+	default:
+	}
 }
 
-type ItemImportanceFlag int
+// Drain sends everything still queued to the listeners straight away, ignoring the batch window, and
+// waits until every listener has caught up or the context is done. Listeners must still be running.
+func (m *MessageQueue) Drain(ctx context.Context) error {
+	m.lock.Lock()
+	m.flushing = true
+	m.wakeListeners()
+	m.lock.Unlock()
 
-const (
-	ItemNormal      ItemImportanceFlag = 0
-	ItemProgression ItemImportanceFlag = 0b001
-	ItemHelpful     ItemImportanceFlag = 0b010
-	ItemTrap        ItemImportanceFlag = 0b100
-)
+	for {
+		m.lock.RLock()
+		remaining := len(m.queue)
+		m.lock.RUnlock()
+		if remaining == 0 {
+			return nil
+		}
 
-type BroadcastMessage struct {
-	Sender     string
-	Receiver   string
-	Item       string
-	Location   string
-	Importance ItemImportanceFlag
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d messages left undelivered: %w", remaining, ctx.Err())
+		case <-m.progress:
+		}
+	}
 }
 
-func (m *messageQueue) TestHandler(message BroadcastMessage) error {
+func (m *MessageQueue) EnqueueMessage(message BroadcastMessage) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if message.Time.IsZero() {
+		message.Time = time.Now()
+	}
+	m.lastSeq++
+	message.Seq = m.lastSeq
+
+	if m.store != nil {
+		err := m.store.append(message)
+		if err != nil {
+			// still deliver it, it just won't survive a restart
+			fmt.Printf("unable to persist message: %s\n", err)
+		}
+	}
+	m.queue = append(m.queue, message)
+	m.wakeListeners()
+}
+
+// wakeListeners must be called with the lock held
+func (m *MessageQueue) wakeListeners() {
+	for _, l := range m.listeners {
+		select {
+		case l.wake <- struct{}{}:
+		default:
+			// already has a wake up pending
+		}
+	}
+}
+
+// Close releases the on disk queue, anything not yet acked is delivered on the next start.
+// It should only be called once Run has returned.
+func (m *MessageQueue) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.store == nil {
+		return nil
+	}
+
+	return m.store.close()
+}
+
+func (m *MessageQueue) TestHandler(messages []BroadcastMessage) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	fmt.Println("---------------------------")
 	//fmt.Printf("%s %s %s %s\n", message.Sender, message.Receiver, message.Item, message.Location)
-	fmt.Printf("%d\n", len(m.queue))
+	fmt.Printf("%d %d\n", len(messages), len(m.queue))
 	fmt.Println("---------------------------")
 	return nil
 }
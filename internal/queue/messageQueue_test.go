@@ -0,0 +1,283 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/civilrights3/go-derek-go/internal/config"
+)
+
+// recorder is a listener that keeps the sequence numbers it was sent
+type recorder struct {
+	lock sync.Mutex
+	seqs []uint64
+	// fail makes the listener fail this many more times before it starts accepting batches
+	fail int
+}
+
+func (r *recorder) send(msgs []BroadcastMessage) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.fail > 0 {
+		r.fail--
+		return errors.New("sink is down")
+	}
+
+	for _, m := range msgs {
+		r.seqs = append(r.seqs, m.Seq)
+	}
+	return nil
+}
+
+func (r *recorder) received() []uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]uint64(nil), r.seqs...)
+}
+
+func testQueueConfig(t *testing.T) config.Queue {
+	return config.Queue{
+		MaxBatch:       50,
+		DeadLetterFile: filepath.Join(t.TempDir(), "dead-letter.jsonl"),
+		Retry: config.Retry{
+			Attempts:   2,
+			MinBackoff: time.Millisecond,
+			MaxBackoff: 5 * time.Millisecond,
+		},
+	}
+}
+
+// startQueue runs the queue until the test ends, the returned channel closes when Run has returned
+func startQueue(t *testing.T, q *MessageQueue) (context.CancelFunc, <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return cancel, done
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// checkInOrder fails unless seqs is exactly first..last
+func checkInOrder(t *testing.T, name string, seqs []uint64, first uint64, last uint64) {
+	t.Helper()
+	if uint64(len(seqs)) != last-first+1 {
+		t.Fatalf("%s got %d messages, want %d", name, len(seqs), last-first+1)
+	}
+	for i, s := range seqs {
+		if s != first+uint64(i) {
+			t.Fatalf("%s got seq %d at %d, want %d", name, s, i, first+uint64(i))
+		}
+	}
+}
+
+func TestRunWakesListenersOnConcurrentEnqueue(t *testing.T) {
+	q, err := New(testQueueConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := &recorder{}, &recorder{}
+	q.RegisterMessageListener("a", a.send)
+	q.RegisterMessageListener("b", b.send)
+	startQueue(t, q)
+
+	const publishers, each = 8, 50
+	wg := sync.WaitGroup{}
+	for p := 0; p < publishers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				q.EnqueueMessage(BroadcastMessage{Type: EventItemSend})
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := uint64(publishers * each)
+	waitFor(t, "every message", func() bool {
+		return uint64(len(a.received())) >= total && uint64(len(b.received())) >= total
+	})
+	checkInOrder(t, "a", a.received(), 1, total)
+	checkInOrder(t, "b", b.received(), 1, total)
+}
+
+func TestRegisterMessageListenerAfterRun(t *testing.T) {
+	q, err := New(testQueueConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	early := &recorder{}
+	q.RegisterMessageListener("early", early.send)
+	startQueue(t, q)
+
+	for i := 0; i < 3; i++ {
+		q.EnqueueMessage(BroadcastMessage{})
+	}
+	waitFor(t, "the early listener", func() bool { return len(early.received()) == 3 })
+
+	// everything so far has been handled, so the late listener only sees what comes after it
+	late := &recorder{}
+	q.RegisterMessageListener("late", late.send)
+	for i := 0; i < 2; i++ {
+		q.EnqueueMessage(BroadcastMessage{})
+	}
+
+	waitFor(t, "the late listener", func() bool { return len(late.received()) == 2 })
+	checkInOrder(t, "late", late.received(), 4, 5)
+	waitFor(t, "the early listener", func() bool { return len(early.received()) == 5 })
+	checkInOrder(t, "early", early.received(), 1, 5)
+}
+
+func TestDrainAfterStoppingPublishers(t *testing.T) {
+	cfg := testQueueConfig(t)
+	// long enough that nothing is delivered unless Drain skips the window
+	cfg.BatchWindow = time.Hour
+	q, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &recorder{}
+	q.RegisterMessageListener("a", a.send)
+	cancel, done := startQueue(t, q)
+
+	// the publisher keeps going until it is told to stop reading, the way a room does at shutdown
+	var stopping atomic.Bool
+	var published atomic.Uint64
+	publisherDone := make(chan struct{})
+	go func() {
+		defer close(publisherDone)
+		for !stopping.Load() {
+			q.EnqueueMessage(BroadcastMessage{})
+			published.Add(1)
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	stopping.Store(true)
+	<-publisherDone
+
+	ctx, drainCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer drainCancel()
+	err = q.Drain(ctx)
+	if err != nil {
+		t.Fatalf("drain: %s", err)
+	}
+	checkInOrder(t, "a", a.received(), 1, published.Load())
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after the context was cancelled")
+	}
+
+	err = q.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDrainGivesUpAtDeadline(t *testing.T) {
+	cfg := testQueueConfig(t)
+	cfg.Retry.Attempts = 1000
+	q, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	down := &recorder{fail: 1 << 30}
+	q.RegisterMessageListener("down", down.send)
+	startQueue(t, q)
+	q.EnqueueMessage(BroadcastMessage{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = q.Drain(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("drain returned %v, want the deadline", err)
+	}
+}
+
+func TestFailingBatchIsDeadLettered(t *testing.T) {
+	cfg := testQueueConfig(t)
+	q, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fails both attempts of the first batch, then takes everything
+	flaky := &recorder{fail: 2}
+	q.RegisterMessageListener("flaky", flaky.send)
+	q.EnqueueMessage(BroadcastMessage{})
+	q.EnqueueMessage(BroadcastMessage{})
+	startQueue(t, q)
+
+	waitFor(t, "the batch to be dead lettered", func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		return q.Drain(ctx) == nil
+	})
+	if got := flaky.received(); len(got) != 0 {
+		t.Fatalf("listener got %v, want nothing", got)
+	}
+
+	f, err := os.Open(cfg.DeadLetterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+	}
+	if lines != 1 {
+		t.Fatalf("dead letter file has %d lines, want 1", lines)
+	}
+}
+
+func TestBatchStaysQueuedWhenDeadLetterFails(t *testing.T) {
+	cfg := testQueueConfig(t)
+	cfg.DeadLetterFile = ""
+	q, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// gives up on the batch twice over before the sink comes back
+	flaky := &recorder{fail: 4}
+	q.RegisterMessageListener("flaky", flaky.send)
+	q.EnqueueMessage(BroadcastMessage{})
+	q.EnqueueMessage(BroadcastMessage{})
+	startQueue(t, q)
+
+	waitFor(t, "the batch to go through", func() bool { return len(flaky.received()) == 2 })
+	checkInOrder(t, "flaky", flaky.received(), 1, 2)
+}
@@ -0,0 +1,269 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentDir       = "segments"
+	segmentExt       = ".log"
+	stateFile        = "state.json"
+	defaultSegmentSz = 1 << 20 // 1MiB
+)
+
+// segmentStore is an append-only log of messages on disk, split into segment files named after
+// the sequence number of their first message. Segments are deleted once every message in them is acked.
+type segmentStore struct {
+	dir         string
+	segmentSize int64
+	segments    []segment
+	current     *os.File
+	currentSize int64
+	state       storeState
+}
+
+type segment struct {
+	path  string
+	first uint64
+	last  uint64
+}
+
+type storeState struct {
+	// Acked is the lowest cursor of the registered listeners, everything up to it can be deleted
+	Acked   uint64            `json:"acked"`
+	Cursors map[string]uint64 `json:"cursors"`
+}
+
+func newSegmentStore(dir string) *segmentStore {
+	return &segmentStore{
+		dir:         dir,
+		segmentSize: defaultSegmentSz,
+	}
+}
+
+// load reads back every message that hasn't been acked yet, oldest first
+func (s *segmentStore) load() ([]BroadcastMessage, error) {
+	err := os.MkdirAll(filepath.Join(s.dir, segmentDir), fs.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create queue directory: %w", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(s.dir, stateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read queue state: %w", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &s.state)
+		if err != nil {
+			return nil, fmt.Errorf("unable to unmarshal queue state: %w", err)
+		}
+	}
+
+	files, err := os.ReadDir(filepath.Join(s.dir, segmentDir))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read queue segments: %w", err)
+	}
+
+	for _, f := range files {
+		first, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentExt), 10, 64)
+		if err != nil || filepath.Ext(f.Name()) != segmentExt {
+			continue
+		}
+		s.segments = append(s.segments, segment{
+			path:  filepath.Join(s.dir, segmentDir, f.Name()),
+			first: first,
+		})
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].first < s.segments[j].first
+	})
+
+	var pending []BroadcastMessage
+	loaded := s.segments[:0]
+	for _, seg := range s.segments {
+		msgs, err := readSegment(seg.path)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(msgs) == 0 {
+			// left behind by a crash between creating a segment and writing to it
+			_ = os.Remove(seg.path)
+			continue
+		}
+
+		seg.last = seg.first
+		for _, m := range msgs {
+			if m.Seq > seg.last {
+				seg.last = m.Seq
+			}
+			if m.Seq > s.state.Acked {
+				pending = append(pending, m)
+			}
+		}
+		loaded = append(loaded, seg)
+	}
+	s.segments = loaded
+
+	return pending, s.compact()
+}
+
+func readSegment(path string) ([]BroadcastMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open queue segment %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var msgs []BroadcastMessage
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		msg := BroadcastMessage{}
+		err = json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			// a torn write from a crash only ever affects the tail of the last segment
+			fmt.Printf("skipping unreadable message in %s: %s\n", path, err)
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, scanner.Err()
+}
+
+// lastSeq is the highest sequence number ever written, so numbering carries on after a restart
+func (s *segmentStore) lastSeq() uint64 {
+	last := s.state.Acked
+	for _, seg := range s.segments {
+		if seg.last > last {
+			last = seg.last
+		}
+	}
+
+	return last
+}
+
+// append writes a message to the end of the log. The write isn't synced, it only has to survive the
+// process going away rather than the machine.
+func (s *segmentStore) append(msg BroadcastMessage) error {
+	if s.current == nil || s.currentSize >= s.segmentSize {
+		err := s.rotate(msg.Seq)
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("unable to marshal message: %w", err)
+	}
+
+	n, err := s.current.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("unable to write message to queue: %w", err)
+	}
+
+	s.currentSize += int64(n)
+	s.segments[len(s.segments)-1].last = msg.Seq
+	return nil
+}
+
+func (s *segmentStore) rotate(first uint64) error {
+	if s.current != nil {
+		err := s.current.Close()
+		if err != nil {
+			return fmt.Errorf("unable to close queue segment: %w", err)
+		}
+	}
+
+	path := filepath.Join(s.dir, segmentDir, fmt.Sprintf("%020d%s", first, segmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fs.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to open queue segment: %w", err)
+	}
+
+	s.current = f
+	s.currentSize = 0
+	s.segments = append(s.segments, segment{path: path, first: first, last: first})
+	return nil
+}
+
+// cursor is the last message a listener handled before the restart
+func (s *segmentStore) cursor(name string) (uint64, bool) {
+	c, ok := s.state.Cursors[name]
+	if ok && c < s.state.Acked {
+		// can't go back to messages that are already deleted
+		return s.state.Acked, true
+	}
+
+	return c, ok
+}
+
+// saveCursor records the position of a listener along with the point every listener has reached
+func (s *segmentStore) saveCursor(name string, seq uint64, acked uint64) error {
+	if s.state.Cursors == nil {
+		s.state.Cursors = make(map[string]uint64)
+	}
+	s.state.Cursors[name] = seq
+	if acked > s.state.Acked {
+		s.state.Acked = acked
+	}
+
+	b, err := json.Marshal(s.state)
+	if err != nil {
+		return fmt.Errorf("unable to marshal queue state: %w", err)
+	}
+
+	// write then rename so a crash never leaves a half written state file
+	tmp := filepath.Join(s.dir, stateFile+".tmp")
+	err = os.WriteFile(tmp, b, fs.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to write queue state: %w", err)
+	}
+
+	err = os.Rename(tmp, filepath.Join(s.dir, stateFile))
+	if err != nil {
+		return fmt.Errorf("unable to replace queue state: %w", err)
+	}
+
+	return s.compact()
+}
+
+// compact deletes the segments that only hold acked messages. The segment being written to is kept
+// until it fills up.
+func (s *segmentStore) compact() error {
+	kept := s.segments[:0]
+	for i, seg := range s.segments {
+		isCurrent := s.current != nil && i == len(s.segments)-1
+		if seg.last <= s.state.Acked && !isCurrent {
+			err := os.Remove(seg.path)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("unable to remove queue segment %s: %w", seg.path, err)
+			}
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	s.segments = kept
+
+	return nil
+}
+
+func (s *segmentStore) close() error {
+	if s.current == nil {
+		return nil
+	}
+
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
@@ -0,0 +1,181 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newStoredQueue(t *testing.T, dir string) *MessageQueue {
+	t.Helper()
+	cfg := testQueueConfig(t)
+	cfg.Dir = dir
+	q, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return q
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, segmentDir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func queuedSeqs(q *MessageQueue) []uint64 {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	var seqs []uint64
+	for _, m := range q.queue {
+		seqs = append(seqs, m.Seq)
+	}
+
+	return seqs
+}
+
+func TestStoreReloadsUnackedMessages(t *testing.T) {
+	dir := t.TempDir()
+	q := newStoredQueue(t, dir)
+	q.RegisterMessageListener("a", (&recorder{}).send)
+	for i := 0; i < 3; i++ {
+		q.EnqueueMessage(BroadcastMessage{Item: "Hookshot"})
+	}
+	err := q.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q = newStoredQueue(t, dir)
+	defer q.Close()
+	checkInOrder(t, "reloaded queue", queuedSeqs(q), 1, 3)
+	if q.queue[0].Item != "Hookshot" {
+		t.Fatalf("reloaded message has item %q", q.queue[0].Item)
+	}
+
+	// numbering carries on from before the restart
+	q.EnqueueMessage(BroadcastMessage{})
+	checkInOrder(t, "queue", queuedSeqs(q), 1, 4)
+}
+
+func TestStoreRecoversListenerCursors(t *testing.T) {
+	dir := t.TempDir()
+	q := newStoredQueue(t, dir)
+	q.RegisterMessageListener("fast", (&recorder{}).send)
+	q.RegisterMessageListener("slow", (&recorder{}).send)
+	for i := 0; i < 4; i++ {
+		q.EnqueueMessage(BroadcastMessage{})
+	}
+	q.advance(q.listeners[0], 3)
+	q.advance(q.listeners[1], 1)
+	err := q.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q = newStoredQueue(t, dir)
+	defer q.Close()
+	// only what the slowest listener hasn't handled is kept
+	checkInOrder(t, "reloaded queue", queuedSeqs(q), 2, 4)
+
+	q.RegisterMessageListener("fast", (&recorder{}).send)
+	q.RegisterMessageListener("slow", (&recorder{}).send)
+	q.RegisterMessageListener("new", (&recorder{}).send)
+
+	tests := []struct {
+		name   string
+		cursor uint64
+	}{
+		{name: "fast", cursor: 3},
+		{name: "slow", cursor: 1},
+		// a listener the store hasn't seen starts with everything still queued
+		{name: "new", cursor: 1},
+	}
+	for i, tt := range tests {
+		if got := q.listeners[i].cursor; got != tt.cursor {
+			t.Errorf("%s starts at %d, want %d", tt.name, got, tt.cursor)
+		}
+	}
+}
+
+func TestStoreCompactsAckedSegments(t *testing.T) {
+	s := newSegmentStore(t.TempDir())
+	// every message gets a segment of its own
+	s.segmentSize = 1
+	_, err := s.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	for seq := uint64(1); seq <= 5; seq++ {
+		err = s.append(BroadcastMessage{Seq: seq})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(segmentFiles(t, s.dir)); got != 5 {
+		t.Fatalf("%d segments before the ack, want 5", got)
+	}
+
+	err = s.saveCursor("a", 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(segmentFiles(t, s.dir)); got != 2 {
+		t.Fatalf("%d segments after acking 3, want 2", got)
+	}
+
+	// the segment being written to stays until it fills up, even once it is acked
+	err = s.saveCursor("a", 5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(segmentFiles(t, s.dir)); got != 1 {
+		t.Fatalf("%d segments after acking everything, want 1", got)
+	}
+}
+
+func TestStoreSkipsTruncatedLastLine(t *testing.T) {
+	dir := t.TempDir()
+	q := newStoredQueue(t, dir)
+	q.RegisterMessageListener("a", (&recorder{}).send)
+	q.EnqueueMessage(BroadcastMessage{})
+	q.EnqueueMessage(BroadcastMessage{})
+	err := q.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash part way through writing the third message
+	files := segmentFiles(t, dir)
+	f, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString(`{"Seq":3,"Ite`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	q = newStoredQueue(t, dir)
+	checkInOrder(t, "reloaded queue", queuedSeqs(q), 1, 2)
+
+	// the torn message never made it, so its number is handed out again
+	q.EnqueueMessage(BroadcastMessage{})
+	checkInOrder(t, "queue", queuedSeqs(q), 1, 3)
+	err = q.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q = newStoredQueue(t, dir)
+	defer q.Close()
+	checkInOrder(t, "queue after a second restart", queuedSeqs(q), 1, 3)
+}
@@ -0,0 +1,80 @@
+package room
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNoHintAnswer is returned when the server doesn't answer a hint request in time
+var ErrNoHintAnswer = errors.New("server did not answer the hint request")
+
+// Status is a snapshot of what the client knows about its room
+type Status struct {
+	Room      string
+	Address   string
+	Connected bool
+	// Slot is the name of the slot the bot is connected as
+	Slot       string
+	HintPoints int
+	Players    []Player
+	// Since is when the client started tracking the room, checks before then aren't counted
+	Since time.Time
+}
+
+type Player struct {
+	Team  int
+	Slot  int
+	Name  string
+	Alias string
+	Game  string
+	// IsPlayer is false for spectators and item link groups
+	IsPlayer bool
+	Status   ClientStatus
+	Goal     bool
+	// Checked is the number of distinct locations seen checked since tracking started, for the bot's
+	// own slot it is every location ever checked
+	Checked int
+	// Total is the number of locations in the slot, for other slots it is every location in their game
+	Total int
+	// ItemsReceived is the number of items seen sent to the player since tracking started, for the
+	// bot's own slot it is every item the slot has ever received
+	ItemsReceived int
+}
+
+// FindPlayer looks a slot up by name or alias, ignoring case
+func (r Status) FindPlayer(name string) (Player, bool) {
+	for _, p := range r.Players {
+		if strings.EqualFold(p.Name, name) || strings.EqualFold(p.Alias, name) {
+			return p, true
+		}
+	}
+
+	return Player{}, false
+}
+
+// ClientStatus is what a slot's client last reported to the server
+type ClientStatus int
+
+const (
+	ClientUnknown   ClientStatus = 0
+	ClientConnected ClientStatus = 5
+	ClientReady     ClientStatus = 10
+	ClientPlaying   ClientStatus = 20
+	ClientGoal      ClientStatus = 30
+)
+
+func (c ClientStatus) String() string {
+	switch c {
+	case ClientConnected:
+		return "connected"
+	case ClientReady:
+		return "ready"
+	case ClientPlaying:
+		return "playing"
+	case ClientGoal:
+		return "goal"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,103 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@yearly",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"1,,2 * * * *",
+	}
+
+	for _, spec := range tests {
+		t.Run(spec, func(t *testing.T) {
+			_, err := Parse(spec)
+			if err == nil {
+				t.Errorf("Parse(%q) didn't fail", spec)
+			}
+		})
+	}
+}
+
+func at(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		from string
+		want string
+	}{
+		{name: "daily", spec: "@daily", from: "2026-03-14 12:30", want: "2026-03-15 00:00"},
+		{name: "exactly on time moves on", spec: "@hourly", from: "2026-03-14 12:00", want: "2026-03-14 13:00"},
+		{name: "seconds are ignored", spec: "30 * * * *", from: "2026-03-14 12:29", want: "2026-03-14 12:30"},
+		{name: "steps", spec: "*/15 * * * *", from: "2026-03-14 12:31", want: "2026-03-14 12:45"},
+		{name: "step from a start", spec: "5/20 * * * *", from: "2026-03-14 12:26", want: "2026-03-14 12:45"},
+		{name: "range and list", spec: "0 9-11,20 * * *", from: "2026-03-14 11:00", want: "2026-03-14 20:00"},
+		{name: "into the next month", spec: "0 0 * * *", from: "2026-04-30 23:59", want: "2026-05-01 00:00"},
+		{name: "into the next year", spec: "@daily", from: "2026-12-31 18:00", want: "2027-01-01 00:00"},
+		{name: "monthly over a year end", spec: "@monthly", from: "2026-12-01 00:00", want: "2027-01-01 00:00"},
+		{name: "day 31 skips short months", spec: "0 12 31 * *", from: "2026-04-01 00:00", want: "2026-05-31 12:00"},
+		{name: "leap day", spec: "0 0 29 2 *", from: "2026-03-01 00:00", want: "2028-02-29 00:00"},
+		{name: "month restricted", spec: "0 0 1 6 *", from: "2026-07-01 00:00", want: "2027-06-01 00:00"},
+		// 2026-03-14 is a Saturday
+		{name: "weekly", spec: "@weekly", from: "2026-03-14 12:00", want: "2026-03-15 00:00"},
+		{name: "7 is Sunday", spec: "0 0 * * 7", from: "2026-03-14 12:00", want: "2026-03-15 00:00"},
+		{name: "weekdays", spec: "0 20 * * 1-5", from: "2026-03-13 21:00", want: "2026-03-16 20:00"},
+		// with both days restricted either one is enough, the 20th comes before the next Monday
+		{name: "day of month or week", spec: "0 0 20 * 1", from: "2026-03-17 00:00", want: "2026-03-20 00:00"},
+		{name: "day of week or month", spec: "0 0 20 * 1", from: "2026-03-20 00:00", want: "2026-03-23 00:00"},
+		// a starred day of month with a step still counts as unrestricted, so only Mondays match
+		{name: "starred step isn't restricted", spec: "0 0 */1 * 1", from: "2026-03-17 00:00", want: "2026-03-23 00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := s.Next(at(tt.from).Add(17 * time.Second))
+			if !got.Equal(at(tt.want)) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got.Format("2006-01-02 15:04 Mon"), tt.want)
+			}
+		})
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := s.Next(at("2026-01-01 00:00"))
+	if !got.IsZero() {
+		t.Errorf("February 31st came round on %s", got)
+	}
+}
@@ -11,6 +11,7 @@ type ArchiServer struct {
 var (
 	testMessages = []queue.BroadcastMessage{
 		{
+			Type:       queue.EventItemSend,
 			Sender:     "Civil",
 			Receiver:   "Tea",
 			Item:       "A bag full of math rocks",
@@ -18,6 +19,7 @@ var (
 			Importance: queue.ItemNormal,
 		},
 		{
+			Type:       queue.EventItemSend,
 			Sender:     "Tea",
 			Receiver:   "Nintendale",
 			Item:       "Way too many checks",
@@ -25,6 +27,7 @@ var (
 			Importance: queue.ItemProgression,
 		},
 		{
+			Type:       queue.EventItemSend,
 			Sender:     "Salty",
 			Receiver:   "EOG",
 			Item:       "Turkey sandwich",
@@ -32,17 +35,35 @@ var (
 			Importance: queue.ItemHelpful,
 		},
 		{
+			Type:       queue.EventItemSend,
 			Sender:     "Iruga",
 			Receiver:   "Iruga",
 			Item:       "A backflip into the void",
 			Location:   "The Navel",
 			Importance: queue.ItemTrap,
 		},
+		{
+			Type:       queue.EventHint,
+			Sender:     "Salty",
+			Receiver:   "Civil",
+			Item:       "The good scissors",
+			Location:   "Behind the fridge",
+			Importance: queue.ItemProgression,
+		},
+		{
+			Type:    queue.EventChat,
+			Player:  "Tea",
+			Message: "who has my hookshot",
+		},
+		{
+			Type:   queue.EventGoal,
+			Player: "Nintendale",
+		},
 	}
 )
 
-func SendTestMessages(_ context.Context) {
+func SendTestMessages(_ context.Context, pub queue.Publisher) {
 	for _, msg := range testMessages {
-		queue.Queue.EnqueueMessage(msg)
+		pub.EnqueueMessage(msg)
 	}
 }